@@ -0,0 +1,68 @@
+package main
+
+import (
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	// BackupPolicyAlways backs up and restores a composed resource regardless of the backup scope
+	BackupPolicyAlways = "Always"
+	// BackupPolicyNever never backs up or restores a composed resource
+	BackupPolicyNever = "Never"
+)
+
+// getBackupPolicy returns the backup policy forced on a composed resource by its backup-policy
+// annotation or the input's selectors, or "" if the backup scope decides
+func (f *Function) getBackupPolicy(req *fnv1.RunFunctionRequest, backup *v1beta1.Backup, resourceName string, fields map[string]*structpb.Value) string {
+	return f.backupPolicy(getAnnotationValueFromResource(req, resourceName, BackupPolicyAnnotation), backup, resourceName, fields)
+}
+
+// backupPolicy returns the backup policy forced by a backup-policy annotation value or the input's selectors
+func (f *Function) backupPolicy(policy string, backup *v1beta1.Backup, resourceName string, fields map[string]*structpb.Value) string {
+	switch policy {
+	case BackupPolicyAlways, BackupPolicyNever:
+		return policy
+	case "":
+	default:
+		f.log.Info("Ignoring unknown backup policy", "resource", resourceName, "backup-policy", policy)
+	}
+
+	if backup == nil {
+		return ""
+	}
+	for _, s := range backup.Exclude {
+		if resourceMatchesSelector(s, fields) {
+			return BackupPolicyNever
+		}
+	}
+	for _, s := range backup.Include {
+		if resourceMatchesSelector(s, fields) {
+			return BackupPolicyAlways
+		}
+	}
+	return ""
+}
+
+// resourceMatchesSelector reports whether a composed resource matches all fields set in the selector
+func resourceMatchesSelector(s v1beta1.ResourceSelector, fields map[string]*structpb.Value) bool {
+	if s.APIVersion != "" {
+		if _, ok := matchWildcard(s.APIVersion, fields["apiVersion"].GetStringValue()); !ok {
+			return false
+		}
+	}
+	if s.Kind != "" {
+		if _, ok := matchWildcard(s.Kind, fields["kind"].GetStringValue()); !ok {
+			return false
+		}
+	}
+	labels := fields["metadata"].GetStructValue().GetFields()["labels"].GetStructValue().GetFields()
+	for k, v := range s.MatchLabels {
+		if l, ok := labels[k]; !ok || l.GetStringValue() != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultClusterID is used when no cluster ID is configured or discovered
+	DefaultClusterID = "default"
+
+	// ClusterIDSourceKubeSystemUID derives the cluster ID from the UID of the kube-system namespace
+	ClusterIDSourceKubeSystemUID = "kube-system-uid"
+
+	// ClusterIDSourceConfigMapPrefix derives the cluster ID from a ConfigMap, e.g. "configmap:crossplane-system/cluster-identity"
+	ClusterIDSourceConfigMapPrefix = "configmap:"
+
+	// ClusterIDConfigMapKey is the ConfigMap data key holding the cluster ID
+	ClusterIDConfigMapKey = "cluster-id"
+)
+
+// ClusterIDDiscoverer derives a cluster ID from a cluster ID source
+type ClusterIDDiscoverer func(ctx context.Context, source string) (string, error)
+
+// discoverClusterID derives a cluster ID from the cluster the function runs in
+func discoverClusterID(ctx context.Context, source string) (string, error) {
+	clientset, err := newKubernetesClient()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case source == ClusterIDSourceKubeSystemUID:
+		ns, err := clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get kube-system namespace: %w", err)
+		}
+		return string(ns.GetUID()), nil
+
+	case strings.HasPrefix(source, ClusterIDSourceConfigMapPrefix):
+		namespace, name, ok := strings.Cut(strings.TrimPrefix(source, ClusterIDSourceConfigMapPrefix), "/")
+		if !ok || namespace == "" || name == "" {
+			return "", fmt.Errorf("invalid cluster ID source %q: expected %s<namespace>/<name>", source, ClusterIDSourceConfigMapPrefix)
+		}
+		cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get ConfigMap '%s/%s': %w", namespace, name, err)
+		}
+		id := cm.Data[ClusterIDConfigMapKey]
+		if id == "" {
+			return "", fmt.Errorf("ConfigMap '%s/%s' has no %q key", namespace, name, ClusterIDConfigMapKey)
+		}
+		return id, nil
+
+	default:
+		return "", fmt.Errorf("unsupported cluster ID source %q (supported sources: '%s', '%s<namespace>/<name>')",
+			source, ClusterIDSourceKubeSystemUID, ClusterIDSourceConfigMapPrefix)
+	}
+}
+
+// completeClusterID sets the cluster ID of the configuration if it isn't set: discovered from the
+// cluster ID source if there is one, otherwise the default
+func (f *Function) completeClusterID(ctx context.Context, config *FunctionConfig) error {
+	if config.ClusterID == "" && config.ClusterIDSource != "" {
+		clusterID, err := f.resolveClusterID(ctx, config.ClusterIDSource)
+		if err != nil {
+			return fmt.Errorf("failed to discover cluster ID from source %q: %w", config.ClusterIDSource, err)
+		}
+		config.ClusterID = clusterID
+		config.Sources[ConfigClusterID] = ConfigSourceDiscovered
+	}
+	if config.ClusterID == "" {
+		config.ClusterID = DefaultClusterID
+		config.Sources[ConfigClusterID] = ConfigSourceDefault
+	}
+	return nil
+}
+
+// resolveClusterID returns the cluster ID for a source, discovering and caching it on first use.
+// Cluster identity doesn't change while the function runs, so cached IDs never expire. Concurrent
+// runs share one discovery per source, and don't wait for the discoveries of other sources.
+func (f *Function) resolveClusterID(ctx context.Context, source string) (string, error) {
+	f.clusterIDsMu.Lock()
+	id, ok := f.clusterIDs[source]
+	f.clusterIDsMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	v, err, _ := f.clusterIDsFlight.Do(source, func() (any, error) {
+		// A discovery may have completed since the cache was checked
+		f.clusterIDsMu.Lock()
+		id, ok := f.clusterIDs[source]
+		f.clusterIDsMu.Unlock()
+		if ok {
+			return id, nil
+		}
+
+		discover := f.discoverClusterID
+		if discover == nil {
+			discover = discoverClusterID
+		}
+		id, err := discover(ctx, source)
+		if err != nil {
+			return "", err
+		}
+
+		f.clusterIDsMu.Lock()
+		if f.clusterIDs == nil {
+			f.clusterIDs = make(map[string]string)
+		}
+		f.clusterIDs[source] = id
+		f.clusterIDsMu.Unlock()
+		f.log.Info("Discovered cluster ID", "source", source, "cluster-id", id)
+		return id, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
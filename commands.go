@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go"
+	"github.com/crossplane/function-sdk-go/errors"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-external-name-backup-restore/plugin/conformance"
+)
+
+// StoreBackendFlags select the external store a command uses. They mirror the
+// store configuration annotations of an XR.
+type StoreBackendFlags struct {
+	StoreType          string `help:"Type of external store: 'awsdynamodb', 'k8sconfigmap', 'git', 'http' or 'plugin'." default:"awsdynamodb" enum:"awsdynamodb,k8sconfigmap,git,http,plugin"`
+	DynamoDBTable      string `name:"dynamodb-table" help:"DynamoDB table name." default:"external-name-backup"`
+	DynamoDBRegion     string `name:"dynamodb-region" help:"DynamoDB region." default:"us-west-2"`
+	ConfigMapNamespace string `name:"configmap-namespace" help:"Namespace of the ConfigMap store." default:"crossplane-system"`
+	GitRepository      string `name:"git-repository" help:"URL of the git store repository."`
+	GitBranch          string `name:"git-branch" help:"Branch of the git store repository." default:"main"`
+	HTTPEndpoint       string `name:"http-endpoint" help:"Base URL of the HTTP store."`
+	HTTPToken          string `name:"http-token" help:"Bearer token of the HTTP store." env:"HTTP_STORE_TOKEN"`
+	PluginAddress      string `name:"plugin-address" help:"gRPC address of the store plugin. Connects without TLS."`
+}
+
+// config returns the function configuration for the store backend flags
+func (s *StoreBackendFlags) config() *FunctionConfig {
+	config := newFunctionConfig()
+	config.apply(ConfigSourceFlags, map[string]string{
+		ConfigStoreType:          s.StoreType,
+		ConfigDynamoDBTable:      s.DynamoDBTable,
+		ConfigDynamoDBRegion:     s.DynamoDBRegion,
+		ConfigConfigMapNamespace: s.ConfigMapNamespace,
+		ConfigGitRepository:      s.GitRepository,
+		ConfigGitBranch:          s.GitBranch,
+		ConfigHTTPEndpoint:       s.HTTPEndpoint,
+		ConfigPluginAddress:      s.PluginAddress,
+	})
+	return config
+}
+
+// creds returns the store credentials given by the flags. AWS credentials come from the default
+// credential chain, and git authenticates as configured in its environment.
+func (s *StoreBackendFlags) creds() map[string]string {
+	if s.StoreType == "http" && s.HTTPToken != "" {
+		return map[string]string{"token": s.HTTPToken}
+	}
+	return nil
+}
+
+// StoreFlags select the external store and the cluster a command operates on.
+type StoreFlags struct {
+	StoreBackendFlags
+
+	ClusterID string `help:"Cluster ID the composition is stored under." required:""`
+}
+
+// config returns the function configuration for the store flags
+func (s *StoreFlags) config() *FunctionConfig {
+	config := s.StoreBackendFlags.config()
+	config.apply(ConfigSourceFlags, map[string]string{ConfigClusterID: s.ClusterID})
+	return config
+}
+
+// PurgeResourcesCmd purges the stored data of selected composed resources of a composition.
+type PurgeResourcesCmd struct {
+	StoreFlags
+
+	CompositionKey string   `help:"Composition key, e.g. 'default/my-claim/example.io/v1alpha1/XExample/my-xr'." required:""`
+	Resource       []string `help:"Pipeline resource name to purge, may contain a single '*' wildcard. Repeat for more resources." required:""`
+	DryRun         bool     `help:"Only print the resource entries that would be purged."`
+}
+
+// Run purges the selected resource entries.
+func (c *PurgeResourcesCmd) Run(g *Globals) error {
+	log, err := function.NewLogger(g.Debug)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	// The store only holds resource names, so kinds can't be matched here
+	for _, r := range c.Resource {
+		if strings.HasPrefix(r, resourceSelectorKindPrefix) {
+			return errors.Errorf("cannot purge %q: kind selectors are only supported by the %s annotation", r, PurgeResourcesAnnotation)
+		}
+	}
+
+	store, err := newStore(ctx, log, c.config(), c.creds())
+	if err != nil {
+		return err
+	}
+
+	resources, err := store.Load(ctx, c.ClusterID, c.CompositionKey)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load resource data from store")
+	}
+	if len(resources) == 0 {
+		return errors.Errorf("no resource data found for composition key %q in cluster %q", c.CompositionKey, c.ClusterID)
+	}
+
+	selector := resourceSelector(c.Resource)
+	for _, name := range slices.Sorted(maps.Keys(resources)) {
+		if !selector.matches(name, "") {
+			continue
+		}
+		if c.DryRun {
+			fmt.Printf("would purge %s (external name %q)\n", name, resources[name].ExternalName)
+			continue
+		}
+		if err := store.DeleteResource(ctx, c.ClusterID, c.CompositionKey, name); err != nil {
+			return errors.Wrapf(err, "failed to purge resource %q from store", name)
+		}
+		fmt.Printf("purged %s (external name %q)\n", name, resources[name].ExternalName)
+	}
+	return nil
+}
+
+// ControllerCmd backs up the external names of managed resources that aren't composed, and
+// restores them into recreated managed resources through a mutating admission webhook.
+type ControllerCmd struct {
+	StoreFlags
+
+	Resource           []string      `help:"Managed resource kind to watch, as '<kind>.<version>.<group>', e.g. 'Bucket.v1beta1.s3.aws.upbound.io'. Repeat for more kinds." required:""`
+	BackupScope        string        `help:"Backup scope: 'orphaned' or 'all'." default:"orphaned" enum:"orphaned,all"`
+	ResyncInterval     time.Duration `help:"How often to re-check all watched managed resources." default:"1h"`
+	WebhookAddress     string        `help:"Address at which to serve the restore admission webhook. The webhook is disabled if empty."`
+	WebhookTLSCertsDir string        `name:"webhook-tls-certs-dir" help:"Directory containing the webhook's serving certs (tls.key, tls.crt)." env:"WEBHOOK_TLS_CERTS_DIR"`
+
+	AllowDefaultClusterID bool `help:"Allow writing to the external store under the 'default' cluster ID." env:"ALLOW_DEFAULT_CLUSTER_ID"`
+}
+
+// Run watches the managed resources and serves the restore admission webhook until interrupted.
+func (c *ControllerCmd) Run(g *Globals) error {
+	log, err := function.NewLogger(g.Debug)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	restConfig, err := newKubernetesConfig()
+	if err != nil {
+		return err
+	}
+	mapper, err := newRESTMapper(restConfig)
+	if err != nil {
+		return err
+	}
+	kinds, resources, err := mapKinds(mapper, c.Resource)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to create dynamic client")
+	}
+
+	store, err := newStore(ctx, log, c.config(), c.creds())
+	if err != nil {
+		return err
+	}
+	controller := newStandaloneController(NewFunction(ctx, log, WithAllowDefaultClusterID(c.AllowDefaultClusterID)), store, c.ClusterID, c.BackupScope, kinds)
+
+	errs := make(chan error, 2)
+	go func() { errs <- controller.watch(ctx, client, resources, c.ResyncInterval) }()
+
+	if c.WebhookAddress != "" {
+		if c.WebhookTLSCertsDir == "" {
+			return errors.New("the restore admission webhook requires --webhook-tls-certs-dir")
+		}
+		mux := http.NewServeMux()
+		mux.Handle(RestoreWebhookPath, controller)
+		srv := &http.Server{Addr: c.WebhookAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Shutdown(context.Background())
+		}()
+		go func() {
+			err := srv.ListenAndServeTLS(filepath.Join(c.WebhookTLSCertsDir, "tls.crt"), filepath.Join(c.WebhookTLSCertsDir, "tls.key"))
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			errs <- errors.Wrap(err, "restore admission webhook failed")
+		}()
+		log.Info("Serving restore admission webhook", "address", c.WebhookAddress, "path", RestoreWebhookPath)
+	}
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return nil
+}
+
+// ImportCmd populates the store with the resource data of the composites in a live cluster.
+type ImportCmd struct {
+	StoreFlags
+
+	Composite   []string `help:"Composite kind to import, as '<kind>.<version>.<group>'. Repeat for more kinds. Defaults to the kinds of all composite resource definitions."`
+	BackupScope string   `help:"Backup scope: 'orphaned' or 'all'. Composites can override it with their backup-scope annotation." default:"orphaned" enum:"orphaned,all"`
+	Input       string   `help:"YAML file with the function input of the composition's pipeline step, whose policy and backup selectors apply." type:"existingfile"`
+	DryRun      bool     `help:"Only print the resource data that would be imported."`
+
+	AllowDefaultClusterID bool `help:"Allow writing to the external store under the 'default' cluster ID." env:"ALLOW_DEFAULT_CLUSTER_ID"`
+}
+
+// Run imports the resource data of the composites.
+func (c *ImportCmd) Run(g *Globals) error {
+	log, err := function.NewLogger(g.Debug)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	restConfig, err := newKubernetesConfig()
+	if err != nil {
+		return err
+	}
+	mapper, err := newRESTMapper(restConfig)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to create dynamic client")
+	}
+
+	var resources []schema.GroupVersionResource
+	if len(c.Composite) > 0 {
+		_, resources, err = mapKinds(mapper, c.Composite)
+	} else {
+		resources, err = compositeResources(ctx, client)
+	}
+	if err != nil {
+		return err
+	}
+
+	store, err := newStore(ctx, log, c.config(), c.creds())
+	if err != nil {
+		return err
+	}
+
+	in := &v1beta1.Input{}
+	if c.Input != "" {
+		b, err := os.ReadFile(c.Input)
+		if err != nil {
+			return errors.Wrap(err, "cannot read function input")
+		}
+		if err := yaml.Unmarshal(b, in); err != nil {
+			return errors.Wrapf(err, "cannot parse function input %q", c.Input)
+		}
+	}
+	config := c.config()
+	config.apply(ConfigSourceFlags, map[string]string{ConfigBackupScope: c.BackupScope})
+
+	i := &importer{
+		f:      NewFunction(ctx, log, WithAllowDefaultClusterID(c.AllowDefaultClusterID)),
+		client: client,
+		mapper: mapper,
+		store:  store,
+		config: config,
+		in:     in,
+		dryRun: c.DryRun,
+		out:    os.Stdout,
+	}
+	_, err = i.run(ctx, resources)
+	return err
+}
+
+// ExportCmd renders the external names stored for a cluster as patches of their composites,
+// so that they can be committed to Git.
+type ExportCmd struct {
+	StoreFlags
+
+	Format string `help:"Output format: 'kustomize' for a kustomize component, or 'annotations' for a YAML stream of composite annotations." default:"kustomize" enum:"kustomize,annotations"`
+	Output string `short:"o" help:"File to write the patches to. Defaults to stdout."`
+}
+
+// Run exports the stored external names.
+func (c *ExportCmd) Run(g *Globals) error {
+	log, err := function.NewLogger(g.Debug)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	store, err := newStore(ctx, log, c.config(), c.creds())
+	if err != nil {
+		return err
+	}
+	lister, ok := store.(CompositionLister)
+	if !ok {
+		return errors.Errorf("the %s store cannot list its compositions", c.StoreType)
+	}
+	compositions, err := lister.ListCompositions(ctx, c.ClusterID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list compositions of cluster %q", c.ClusterID)
+	}
+
+	composites, claimed, skipped := exportComposites(compositions)
+	for _, key := range skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s: not the composition key of a composite\n", key)
+	}
+	for _, cc := range claimed {
+		names, err := cc.annotation()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "skipped %s: the composite of claim %s/%s has a generated name, set %s='%s' on the claim instead\n",
+			cc.compositionKey, cc.parts.Namespace, cc.parts.ClaimName, ExternalNamesAnnotation, names)
+	}
+
+	if c.Output == "" {
+		err = renderExport(os.Stdout, c.Format, c.ClusterID, composites)
+	} else {
+		err = c.writeFile(composites)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported external names of %d composites of cluster %q, %d composites of claims and %d keys skipped\n",
+		len(composites), c.ClusterID, len(claimed), len(skipped))
+	return nil
+}
+
+// writeFile renders the export to the output file
+func (c *ExportCmd) writeFile(composites []exportedComposite) error {
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %s", c.Output)
+	}
+	if err := renderExport(f, c.Format, c.ClusterID, composites); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return errors.Wrapf(f.Close(), "cannot write %s", c.Output)
+}
+
+// ServeStoreCmd serves the HTTP store protocol, backed by another store, as a reference server.
+type ServeStoreCmd struct {
+	StoreBackendFlags
+
+	Address     string `help:"Address at which to serve the HTTP store protocol." default:":8080"`
+	TLSCertsDir string `name:"tls-certs-dir" help:"Directory containing the server certs (tls.key, tls.crt) and, to require client certificates, the CA used to verify them (ca.crt). Serves plain HTTP if empty." env:"STORE_TLS_CERTS_DIR"`
+	Token       string `help:"Bearer token clients must present. Not required if empty." env:"STORE_TOKEN"`
+}
+
+// Run serves the HTTP store protocol until interrupted.
+func (c *ServeStoreCmd) Run(g *Globals) error {
+	log, err := function.NewLogger(g.Debug)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := newStore(ctx, log, c.config(), c.creds())
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: c.Address, Handler: newStoreServer(log, store, c.Token), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	if c.TLSCertsDir == "" {
+		log.Info("Serving HTTP store protocol without TLS", "address", c.Address, "store-type", c.StoreType)
+		err = srv.ListenAndServe()
+	} else {
+		if srv.TLSConfig, err = serverTLSConfig(c.TLSCertsDir); err != nil {
+			return err
+		}
+		log.Info("Serving HTTP store protocol", "address", c.Address, "store-type", c.StoreType, "client-certificates", srv.TLSConfig.ClientCAs != nil)
+		err = srv.ListenAndServeTLS(filepath.Join(c.TLSCertsDir, "tls.crt"), filepath.Join(c.TLSCertsDir, "tls.key"))
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return errors.Wrap(err, "HTTP store server failed")
+}
+
+// serverTLSConfig returns the TLS configuration of the HTTP store server, which requires client
+// certificates if the certs directory contains a CA to verify them
+func serverTLSConfig(dir string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read client CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("cannot parse client CA")
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// CheckPluginCmd runs the conformance checks of the store plugin service against a running plugin.
+type CheckPluginCmd struct {
+	PluginAddress string `help:"gRPC address of the store plugin, e.g. 'localhost:9555' or 'unix:///var/run/store.sock'." required:""`
+	TLSCertsDir   string `name:"tls-certs-dir" help:"Directory containing the CA that verifies the plugin (ca.crt) and optionally a client certificate (tls.crt, tls.key). Connects without TLS if empty."`
+}
+
+// Run runs the conformance checks and reports each check's result.
+func (c *CheckPluginCmd) Run(_ *Globals) error {
+	creds := make(map[string]string)
+	if c.TLSCertsDir != "" {
+		for _, name := range []string{"ca.crt", "tls.crt", "tls.key"} {
+			b, err := os.ReadFile(filepath.Join(c.TLSCertsDir, name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "cannot read %s", name)
+			}
+			creds[name] = string(b)
+		}
+	}
+	// Unhealthy plugins are still checked, the health check reports them
+	conn, err := dialPlugin(c.PluginAddress, creds)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	failed := 0
+	for _, check := range conformance.Checks() {
+		if err := conformance.RunCheck(context.Background(), conn, check); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", check.Name, err)
+			continue
+		}
+		fmt.Printf("PASS %s\n", check.Name)
+	}
+	if failed > 0 {
+		return errors.Errorf("store plugin %q failed %d of %d conformance checks", c.PluginAddress, failed, len(conformance.Checks()))
+	}
+	fmt.Printf("Store plugin %q passed all %d conformance checks\n", c.PluginAddress, len(conformance.Checks()))
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+)
+
+// compositionKeyParts holds the components of a composition key
+type compositionKeyParts struct {
+	Namespace  string
+	ClaimName  string
+	APIVersion string
+	Kind       string
+	Name       string
+}
+
+// String formats the composition key as {namespace}/{claimName}/{apiVersionOfXr}/{kindOfXr}/{metadata.name of XR}
+func (p compositionKeyParts) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", p.Namespace, p.ClaimName, p.APIVersion, p.Kind, p.Name)
+}
+
+// compositeKeyParts derives the composition key parts from a composite's API version, kind, name
+// and claim labels. Composites without a claim use their own namespace, or "none" if cluster scoped.
+func compositeKeyParts(composite *structpb.Struct) compositionKeyParts {
+	fields := composite.GetFields()
+	metadata := fields["metadata"].GetStructValue().GetFields()
+	labels := metadata["labels"].GetStructValue().GetFields()
+
+	parts := compositionKeyParts{
+		Namespace:  labels["crossplane.io/claim-namespace"].GetStringValue(),
+		ClaimName:  labels["crossplane.io/claim-name"].GetStringValue(),
+		APIVersion: fields["apiVersion"].GetStringValue(),
+		Kind:       fields["kind"].GetStringValue(),
+		Name:       metadata["name"].GetStringValue(),
+	}
+	if parts.Namespace == "" {
+		parts.Namespace = metadata["namespace"].GetStringValue()
+	}
+	if parts.Namespace == "" {
+		parts.Namespace = "none"
+	}
+	if parts.ClaimName == "" {
+		parts.ClaimName = "none"
+	}
+	return parts
+}
+
+// applyKeyOverrides applies the composition key override annotations, as returned by the
+// annotation getter, to the key parts and returns the composition key
+func (f *Function) applyKeyOverrides(parts *compositionKeyParts, annotation func(string) string) string {
+	keyOverrides := []struct {
+		annotation string
+		component  *string
+	}{
+		{annotation: OverrideNamespaceAnnotation, component: &parts.Namespace},
+		{annotation: OverrideClaimNameAnnotation, component: &parts.ClaimName},
+		{annotation: OverrideAPIVersionAnnotation, component: &parts.APIVersion},
+		{annotation: OverrideKindAnnotation, component: &parts.Kind},
+		{annotation: OverrideNameAnnotation, component: &parts.Name},
+	}
+	for _, o := range keyOverrides {
+		if override := annotation(o.annotation); override != "" {
+			f.log.Info("Using override for composition key lookup",
+				"annotation", o.annotation,
+				"original", *o.component,
+				"override", override)
+			*o.component = override
+		}
+	}
+
+	// Create composition key: {namespace}/{claimName}/{apiVersionOfXr}/{kindOfXr}/{metadata.name of XR}
+	compositionKey := parts.String()
+	if overrideKey := annotation(OverrideCompositionKeyAnnotation); overrideKey != "" {
+		f.log.Info("Using override-composition-key for composition key lookup",
+			"original-composition-key", compositionKey,
+			"override-composition-key", overrideKey)
+		compositionKey = overrideKey
+	}
+	return compositionKey
+}
+
+// storeLocation identifies where a composition's resource data lives in a store
+type storeLocation struct {
+	ClusterID      string
+	CompositionKey string
+}
+
+// resolveFallbackKeys turns fallback key candidates into store locations.
+// Components left empty in a candidate take the value of the current key.
+// Candidates that resolve to the current location are dropped, as are duplicates.
+func resolveFallbackKeys(candidates []v1beta1.CompositionKeyCandidate, clusterID string, current compositionKeyParts) []storeLocation {
+	seen := map[storeLocation]bool{
+		{ClusterID: clusterID, CompositionKey: current.String()}: true,
+	}
+
+	locations := make([]storeLocation, 0, len(candidates))
+	for _, c := range candidates {
+		parts := current
+		if c.Namespace != "" {
+			parts.Namespace = c.Namespace
+		}
+		if c.ClaimName != "" {
+			parts.ClaimName = c.ClaimName
+		}
+		if c.APIVersion != "" {
+			parts.APIVersion = c.APIVersion
+		}
+		if c.Kind != "" {
+			parts.Kind = c.Kind
+		}
+		if c.Name != "" {
+			parts.Name = c.Name
+		}
+
+		loc := storeLocation{ClusterID: clusterID, CompositionKey: parts.String()}
+		if c.ClusterID != "" {
+			loc.ClusterID = c.ClusterID
+		}
+
+		if seen[loc] {
+			continue
+		}
+		seen[loc] = true
+		locations = append(locations, loc)
+	}
+	return locations
+}
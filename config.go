@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+)
+
+// Configuration settings, used as ConfigMap keys and in the effective configuration report
+const (
+	ConfigClusterID          = "cluster-id"
+	ConfigClusterIDSource    = "cluster-id-source"
+	ConfigStoreType          = "store-type"
+	ConfigDynamoDBTable      = "dynamodb-table"
+	ConfigDynamoDBRegion     = "dynamodb-region"
+	ConfigConfigMapNamespace = "configmap-namespace"
+	ConfigConfigMapReads     = "configmap-reads"
+	ConfigBackupScope        = "backup-scope"
+	ConfigGitRepository      = "git-repository"
+	ConfigGitBranch          = "git-branch"
+	ConfigHTTPEndpoint       = "http-endpoint"
+	ConfigPluginAddress      = "plugin-address"
+)
+
+const (
+	// ConfigMapReadsAPI reads the ConfigMap store from the Kubernetes API
+	ConfigMapReadsAPI = "api"
+	// ConfigMapReadsRequiredResources reads the ConfigMap store from required resources supplied by Crossplane
+	ConfigMapReadsRequiredResources = "required-resources"
+)
+
+// Configuration sources, from lowest to highest precedence
+const (
+	// ConfigSourceDefault is a built-in default
+	ConfigSourceDefault = "default"
+	// ConfigSourceFlags is a flag or environment variable of the function
+	ConfigSourceFlags = "flags"
+	// ConfigSourceConfigMap is the function's configuration ConfigMap
+	ConfigSourceConfigMap = "configmap"
+	// ConfigSourceEnvironment is the Crossplane environment in the pipeline context
+	ConfigSourceEnvironment = "environment"
+	// ConfigSourceInput is the function input of the composition
+	ConfigSourceInput = "input"
+	// ConfigSourceAnnotation is an XR annotation
+	ConfigSourceAnnotation = "annotation"
+	// ConfigSourcePolicy is a store setting pinned by the input policy
+	ConfigSourcePolicy = "policy"
+	// ConfigSourceDiscovered is a cluster ID discovered from the cluster ID source
+	ConfigSourceDiscovered = "discovered"
+)
+
+const (
+	// EnvironmentContextKey is the pipeline context key Crossplane writes the environment to
+	EnvironmentContextKey = "apiextensions.crossplane.io/environment"
+	// EnvironmentConfigField is the environment field holding the function configuration,
+	// with the same fields as the config section of the function input
+	EnvironmentConfigField = "externalNameBackup"
+)
+
+// configWatchSyncTimeout bounds the wait for the initial sync of the configuration ConfigMap
+const configWatchSyncTimeout = 30 * time.Second
+
+// configSettings are the configuration settings. Settings without an annotation can't be set by XRs.
+var configSettings = []struct {
+	name       string
+	annotation string
+	field      func(c *FunctionConfig) *string
+}{
+	{name: ConfigClusterID, annotation: ClusterIDAnnotation, field: func(c *FunctionConfig) *string { return &c.ClusterID }},
+	{name: ConfigClusterIDSource, annotation: ClusterIDSourceAnnotation, field: func(c *FunctionConfig) *string { return &c.ClusterIDSource }},
+	{name: ConfigStoreType, annotation: StoreTypeAnnotation, field: func(c *FunctionConfig) *string { return &c.StoreType }},
+	{name: ConfigDynamoDBTable, annotation: DynamoDBTableAnnotation, field: func(c *FunctionConfig) *string { return &c.DynamoDBTable }},
+	{name: ConfigDynamoDBRegion, annotation: DynamoDBRegionAnnotation, field: func(c *FunctionConfig) *string { return &c.DynamoDBRegion }},
+	{name: ConfigConfigMapNamespace, annotation: ConfigMapNamespaceAnnotation, field: func(c *FunctionConfig) *string { return &c.ConfigMapNamespace }},
+	{name: ConfigConfigMapReads, annotation: ConfigMapReadsAnnotation, field: func(c *FunctionConfig) *string { return &c.ConfigMapReads }},
+	{name: ConfigBackupScope, annotation: BackupScopeAnnotation, field: func(c *FunctionConfig) *string { return &c.BackupScope }},
+	// The git store sends its credentials to the repository, so XRs can't redirect it
+	{name: ConfigGitRepository, field: func(c *FunctionConfig) *string { return &c.GitRepository }},
+	{name: ConfigGitBranch, annotation: GitBranchAnnotation, field: func(c *FunctionConfig) *string { return &c.GitBranch }},
+	// The HTTP store sends its credentials to the endpoint, so XRs can't redirect it
+	{name: ConfigHTTPEndpoint, field: func(c *FunctionConfig) *string { return &c.HTTPEndpoint }},
+	// The plugin store sends its credentials to the plugin, so XRs can't redirect it
+	{name: ConfigPluginAddress, field: func(c *FunctionConfig) *string { return &c.PluginAddress }},
+}
+
+// A ConfigLoader returns the current configuration values of a configuration layer, keyed by setting
+type ConfigLoader func() (map[string]string, error)
+
+// configValue is a setting of the effective configuration and the layer it came from
+type configValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// newFunctionConfig returns the built-in default configuration.
+// ClusterID stays empty, so that it can be discovered from ClusterIDSource.
+func newFunctionConfig() *FunctionConfig {
+	c := &FunctionConfig{Sources: make(map[string]string)}
+	c.apply(ConfigSourceDefault, map[string]string{
+		ConfigStoreType:          "awsdynamodb",
+		ConfigDynamoDBTable:      "external-name-backup",
+		ConfigDynamoDBRegion:     "us-west-2",
+		ConfigConfigMapNamespace: "crossplane-system",
+		ConfigConfigMapReads:     ConfigMapReadsAPI,
+		ConfigBackupScope:        BackupScopeOrphaned,
+		ConfigGitBranch:          "main",
+	})
+	return c
+}
+
+// apply overrides the configuration with the non-empty values of a configuration layer
+func (c *FunctionConfig) apply(source string, values map[string]string) {
+	// A cluster ID source replaces a cluster ID of a lower layer, so that each layer can turn on discovery
+	if values[ConfigClusterIDSource] != "" && values[ConfigClusterID] == "" {
+		c.ClusterID = ""
+		delete(c.Sources, ConfigClusterID)
+	}
+	for _, s := range configSettings {
+		if v := values[s.name]; v != "" {
+			*s.field(c) = v
+			c.Sources[s.name] = source
+		}
+	}
+}
+
+// effective returns the set configuration values and their sources
+func (c *FunctionConfig) effective() map[string]configValue {
+	values := make(map[string]configValue)
+	for _, s := range configSettings {
+		if v := *s.field(c); v != "" {
+			values[s.name] = configValue{Value: v, Source: c.Sources[s.name]}
+		}
+	}
+	return values
+}
+
+// getConfig layers the configuration: built-in defaults, then the function's flags, then its
+// ConfigMap, then the Crossplane environment, then the function input, then XR annotations
+func (f *Function) getConfig(req *fnv1.RunFunctionRequest, in *v1beta1.Input) (*FunctionConfig, error) {
+	config := newFunctionConfig()
+	config.apply(ConfigSourceFlags, f.configFlags)
+
+	if f.loadConfigMap != nil {
+		values, err := f.loadConfigMap()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load configuration ConfigMap")
+		}
+		config.apply(ConfigSourceConfigMap, values)
+	}
+
+	env, err := getEnvironmentConfig(req)
+	if err != nil {
+		return nil, err
+	}
+	config.apply(ConfigSourceEnvironment, configValues(env))
+
+	config.apply(ConfigSourceInput, configValues(in.Config))
+
+	annotations := make(map[string]string)
+	for _, s := range configSettings {
+		if s.annotation != "" {
+			annotations[s.name] = getCompositeConfigAnnotation(req, s.annotation)
+		}
+	}
+	config.apply(ConfigSourceAnnotation, annotations)
+
+	// The restore cluster ID only applies to a single XR, so it's only set by annotation
+	config.RestoreClusterID = getCompositeConfigAnnotation(req, RestoreClusterIDAnnotation)
+
+	return config, nil
+}
+
+// configValues returns the values of an input or environment config section, keyed by setting
+func configValues(c *v1beta1.Config) map[string]string {
+	if c == nil {
+		return nil
+	}
+	return map[string]string{
+		ConfigClusterID:          c.ClusterID,
+		ConfigClusterIDSource:    c.ClusterIDSource,
+		ConfigStoreType:          c.StoreType,
+		ConfigDynamoDBTable:      c.DynamoDBTable,
+		ConfigDynamoDBRegion:     c.DynamoDBRegion,
+		ConfigConfigMapNamespace: c.ConfigMapNamespace,
+		ConfigConfigMapReads:     c.ConfigMapReads,
+		ConfigBackupScope:        c.BackupScope,
+		ConfigGitRepository:      c.GitRepository,
+		ConfigGitBranch:          c.GitBranch,
+		ConfigHTTPEndpoint:       c.HTTPEndpoint,
+		ConfigPluginAddress:      c.PluginAddress,
+	}
+}
+
+// storeClusterIDs returns the cluster IDs the store may be read from: the cluster ID, the restore
+// cluster ID and the cluster IDs of the fallback keys
+func storeClusterIDs(config *FunctionConfig, in *v1beta1.Input) []string {
+	ids := []string{config.ClusterID}
+	if config.RestoreClusterID != "" {
+		ids = append(ids, config.RestoreClusterID)
+	}
+	if in.Restore != nil {
+		for _, k := range in.Restore.FallbackKeys {
+			if k.ClusterID != "" {
+				ids = append(ids, k.ClusterID)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// getEnvironmentConfig returns the function configuration in the Crossplane environment, or nil if there is none
+func getEnvironmentConfig(req *fnv1.RunFunctionRequest) (*v1beta1.Config, error) {
+	env, ok := request.GetContextKey(req, EnvironmentContextKey)
+	if !ok {
+		return nil, nil
+	}
+	v, ok := env.GetStructValue().GetFields()[EnvironmentConfigField]
+	if !ok {
+		return nil, nil
+	}
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot marshal environment field %s", EnvironmentConfigField)
+	}
+	c := &v1beta1.Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse environment field %s", EnvironmentConfigField)
+	}
+	return c, nil
+}
+
+// describe returns the effective configuration and the source of each value, in precedence order of the settings
+func (c *FunctionConfig) describe() string {
+	var values []string
+	for _, s := range configSettings {
+		if v := *s.field(c); v != "" {
+			values = append(values, fmt.Sprintf("%s=%q (%s)", s.name, v, c.Sources[s.name]))
+		}
+	}
+	if c.RestoreClusterID != "" {
+		values = append(values, fmt.Sprintf("restore-cluster-id=%q (%s)", c.RestoreClusterID, ConfigSourceAnnotation))
+	}
+	return strings.Join(values, ", ")
+}
+
+// watchConfigMap watches the configuration ConfigMap, given as <namespace>/<name>, and returns a
+// loader for its current data. A missing ConfigMap is an empty configuration layer.
+func watchConfigMap(ctx context.Context, ref string) (ConfigLoader, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, errors.Errorf("invalid configuration ConfigMap %q: expected <namespace>/<name>", ref)
+	}
+
+	clientset, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	lister := factory.Core().V1().ConfigMaps().Lister()
+	factory.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, configWatchSyncTimeout)
+	defer cancel()
+	for _, synced := range factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			return nil, errors.Errorf("failed to watch configuration ConfigMap '%s/%s'", namespace, name)
+		}
+	}
+
+	return func() (map[string]string, error) {
+		cm, err := lister.ConfigMaps(namespace).Get(name)
+		if kerrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get ConfigMap '%s/%s'", namespace, name)
+		}
+		return configMapData(cm), nil
+	}, nil
+}
+
+// configMapData returns the configuration settings of a ConfigMap, ignoring other keys
+func configMapData(cm *corev1.ConfigMap) map[string]string {
+	values := make(map[string]string)
+	for _, s := range configSettings {
+		values[s.name] = strings.TrimSpace(cm.Data[s.name])
+	}
+	return values
+}
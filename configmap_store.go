@@ -2,17 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	// ConfigMapStoreClusterIDLabel labels the store ConfigMaps with their cluster ID, so that they
+	// can be requested as required resources
+	ConfigMapStoreClusterIDLabel = "fn.crossplane.io/external-name-backup-cluster-id"
+
+	// ConfigMapStoreRequirementPrefix prefixes the required resource keys of the store ConfigMaps
+	ConfigMapStoreRequirementPrefix = "external-name-backup-store/"
 )
 
 // ConfigMapStore implements ResourceStore using Kubernetes ConfigMaps
@@ -20,6 +34,10 @@ type ConfigMapStore struct {
 	client    kubernetes.Interface
 	namespace string
 	log       logging.Logger
+
+	// snapshot holds the store ConfigMaps supplied as required resources, by name. When set,
+	// reads use it instead of the Kubernetes API, and the client is only created for writes.
+	snapshot map[string]*corev1.ConfigMap
 }
 
 // NewConfigMapStore creates a new ConfigMap store
@@ -28,16 +46,9 @@ func NewConfigMapStore(ctx context.Context, log logging.Logger, namespace string
 		namespace = "crossplane-system"
 	}
 
-	// Create in-cluster config
-	config, err := rest.InClusterConfig()
+	clientset, err := newKubernetesClient()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create in-cluster config: %w", err)
-	}
-
-	// Create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		return nil, err
 	}
 
 	store := &ConfigMapStore{
@@ -56,6 +67,179 @@ func NewConfigMapStore(ctx context.Context, log logging.Logger, namespace string
 	return store, nil
 }
 
+// NewConfigMapSnapshotStore creates a ConfigMap store that reads from the store ConfigMaps
+// supplied as required resources
+func NewConfigMapSnapshotStore(log logging.Logger, namespace string, snapshot map[string]*corev1.ConfigMap) *ConfigMapStore {
+	if namespace == "" {
+		namespace = "crossplane-system"
+	}
+	return &ConfigMapStore{
+		namespace: namespace,
+		log:       log,
+		snapshot:  snapshot,
+	}
+}
+
+// getClient returns the Kubernetes client, creating it on first use
+func (c *ConfigMapStore) getClient() (kubernetes.Interface, error) {
+	if c.client == nil {
+		clientset, err := newKubernetesClient()
+		if err != nil {
+			return nil, err
+		}
+		c.client = clientset
+	}
+	return c.client, nil
+}
+
+// requireStoreConfigMaps requests the store ConfigMaps of the cluster IDs as required resources
+func requireStoreConfigMaps(rsp *fnv1.RunFunctionResponse, clusterIDs []string) {
+	if rsp.GetRequirements() == nil {
+		rsp.Requirements = &fnv1.Requirements{}
+	}
+	if rsp.GetRequirements().GetExtraResources() == nil {
+		rsp.Requirements.ExtraResources = make(map[string]*fnv1.ResourceSelector)
+	}
+	for _, id := range clusterIDs {
+		rsp.Requirements.ExtraResources[ConfigMapStoreRequirementPrefix+id] = &fnv1.ResourceSelector{
+			ApiVersion: "v1",
+			Kind:       "ConfigMap",
+			Match: &fnv1.ResourceSelector_MatchLabels{
+				MatchLabels: &fnv1.MatchLabels{Labels: map[string]string{ConfigMapStoreClusterIDLabel: clusterIDLabelValue(id)}},
+			},
+		}
+	}
+}
+
+// getStoreConfigMaps returns the store ConfigMaps of the cluster IDs in the namespace, by name, from the
+// required resources. It returns false if Crossplane hasn't supplied all of them yet.
+func getStoreConfigMaps(req *fnv1.RunFunctionRequest, namespace string, clusterIDs []string) (map[string]*corev1.ConfigMap, bool, error) {
+	snapshot := make(map[string]*corev1.ConfigMap)
+	for _, id := range clusterIDs {
+		resources, ok := req.GetExtraResources()[ConfigMapStoreRequirementPrefix+id]
+		if !ok {
+			return nil, false, nil
+		}
+		for _, item := range resources.GetItems() {
+			b, err := item.GetResource().MarshalJSON()
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to marshal store ConfigMap: %w", err)
+			}
+			cm := &corev1.ConfigMap{}
+			if err := json.Unmarshal(b, cm); err != nil {
+				return nil, false, fmt.Errorf("failed to parse store ConfigMap: %w", err)
+			}
+			// The selector matches ConfigMaps in all namespaces
+			if cm.GetNamespace() == namespace {
+				snapshot[cm.GetName()] = cm
+			}
+		}
+	}
+	return snapshot, true, nil
+}
+
+// getConfigMap gets the store ConfigMap of a cluster from the snapshot if there is one, or from the
+// Kubernetes API. ConfigMaps written by older versions have no cluster ID label, so Crossplane doesn't
+// supply them as required resources. A ConfigMap missing from the snapshot is therefore read from the
+// API if the function can, and ConfigMaps read from the API are labeled, so that Crossplane supplies
+// them from then on.
+func (c *ConfigMapStore) getConfigMap(ctx context.Context, clusterID, name string) (*corev1.ConfigMap, error) {
+	if c.snapshot != nil {
+		if cm, ok := c.snapshot[name]; ok {
+			return cm.DeepCopy(), nil
+		}
+	}
+	client, err := c.getClient()
+	if err != nil {
+		if c.snapshot != nil {
+			// Offline, e.g. in crossplane render
+			c.log.Debug("Cannot read store ConfigMap missing from the required resources", "configmap", name, "error", err.Error())
+			return nil, errors.NewNotFound(corev1.Resource("configmaps"), name)
+		}
+		return nil, err
+	}
+	cm, err := client.CoreV1().ConfigMaps(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if c.snapshot != nil && !errors.IsNotFound(err) {
+			// Reads may be left to Crossplane, without RBAC for the function
+			c.log.Info("Cannot read store ConfigMap missing from the required resources", "configmap", name, "error", err.Error())
+			return nil, errors.NewNotFound(corev1.Resource("configmaps"), name)
+		}
+		return nil, err
+	}
+
+	if cm.GetLabels()[ConfigMapStoreClusterIDLabel] != clusterIDLabelValue(clusterID) {
+		setClusterIDLabel(cm, clusterID)
+		if labeled, err := client.CoreV1().ConfigMaps(c.namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			c.log.Info("Cannot label store ConfigMap with its cluster ID", "configmap", name, "error", err.Error())
+		} else {
+			cm = labeled
+			c.log.Info("Labeled store ConfigMap with its cluster ID", "configmap", name, "cluster-id", clusterID)
+		}
+	}
+	c.updateSnapshot(name, cm)
+	return cm.DeepCopy(), nil
+}
+
+// updateSnapshot keeps the snapshot, if there is one, in sync with a write. A nil ConfigMap was deleted.
+func (c *ConfigMapStore) updateSnapshot(name string, cm *corev1.ConfigMap) {
+	if c.snapshot == nil {
+		return
+	}
+	if cm == nil {
+		delete(c.snapshot, name)
+		return
+	}
+	c.snapshot[name] = cm
+}
+
+// clusterIDLabelValue returns the cluster ID label value of a cluster ID. Cluster IDs that aren't
+// valid label values, e.g. because they are longer than 63 characters, are labeled with a hash.
+func clusterIDLabelValue(clusterID string) string {
+	if len(validation.IsValidLabelValue(clusterID)) == 0 {
+		return clusterID
+	}
+	sum := sha256.Sum256([]byte(clusterID))
+	return "sha256-" + hex.EncodeToString(sum[:])[:56]
+}
+
+// setClusterIDLabel labels a store ConfigMap with its cluster ID
+func setClusterIDLabel(cm *corev1.ConfigMap, clusterID string) {
+	if cm.Labels == nil {
+		cm.Labels = make(map[string]string)
+	}
+	cm.Labels[ConfigMapStoreClusterIDLabel] = clusterIDLabelValue(clusterID)
+}
+
+// newKubernetesConfig returns the in-cluster config, falling back to the kubeconfig
+// (KUBECONFIG or ~/.kube/config) when running outside a cluster, e.g. from the CLI
+func newKubernetesConfig() (*rest.Config, error) {
+	config, inClusterErr := rest.InClusterConfig()
+	if inClusterErr != nil {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		var err error
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes client config: in-cluster: %w, kubeconfig: %w", inClusterErr, err)
+		}
+	}
+	return config, nil
+}
+
+// newKubernetesClient creates a Kubernetes client from the in-cluster config or kubeconfig
+func newKubernetesClient() (kubernetes.Interface, error) {
+	config, err := newKubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return clientset, nil
+}
+
 // getConfigMapName returns the ConfigMap name for a given cluster ID
 func (c *ConfigMapStore) getConfigMapName(clusterID string) string {
 	return fmt.Sprintf("external-name-backup-%s", clusterID)
@@ -77,8 +261,13 @@ func (c *ConfigMapStore) Save(ctx context.Context, clusterID, compositionKey str
 		return fmt.Errorf("failed to marshal resources to JSON: %w", err)
 	}
 
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
 	// Try to get existing ConfigMap
-	configMap, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	configMap, err := client.CoreV1().ConfigMaps(c.namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Create new ConfigMap
@@ -91,10 +280,12 @@ func (c *ConfigMapStore) Save(ctx context.Context, clusterID, compositionKey str
 					encodedKey: string(resourcesJSON),
 				},
 			}
-			_, err = c.client.CoreV1().ConfigMaps(c.namespace).Create(ctx, configMap, metav1.CreateOptions{})
+			setClusterIDLabel(configMap, clusterID)
+			_, err = client.CoreV1().ConfigMaps(c.namespace).Create(ctx, configMap, metav1.CreateOptions{})
 			if err != nil {
 				return fmt.Errorf("failed to create ConfigMap: %w", err)
 			}
+			c.updateSnapshot(configMapName, configMap)
 			c.log.Debug("Created ConfigMap for cluster", "configmap", configMapName, "cluster-id", clusterID)
 			return nil
 		}
@@ -106,11 +297,13 @@ func (c *ConfigMapStore) Save(ctx context.Context, clusterID, compositionKey str
 		configMap.Data = make(map[string]string)
 	}
 	configMap.Data[encodedKey] = string(resourcesJSON)
+	setClusterIDLabel(configMap, clusterID)
 
-	_, err = c.client.CoreV1().ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	_, err = client.CoreV1().ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update ConfigMap: %w", err)
 	}
+	c.updateSnapshot(configMapName, configMap)
 
 	c.log.Debug("Updated ConfigMap for composition", "configmap", configMapName, "composition-key", compositionKey)
 	return nil
@@ -122,7 +315,7 @@ func (c *ConfigMapStore) Load(ctx context.Context, clusterID, compositionKey str
 	encodedKey := c.encodeKey(compositionKey)
 
 	// Get the ConfigMap
-	configMap, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	configMap, err := c.getConfigMap(ctx, clusterID, configMapName)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			c.log.Debug("ConfigMap not found, returning empty data", "configmap", configMapName)
@@ -148,6 +341,35 @@ func (c *ConfigMapStore) Load(ctx context.Context, clusterID, compositionKey str
 	return resources, nil
 }
 
+// ListCompositions retrieves the resource data of all compositions of a cluster from its ConfigMap
+func (c *ConfigMapStore) ListCompositions(ctx context.Context, clusterID string) (map[string]map[string]ResourceData, error) {
+	configMapName := c.getConfigMapName(clusterID)
+	compositions := make(map[string]map[string]ResourceData)
+
+	configMap, err := c.getConfigMap(ctx, clusterID, configMapName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return compositions, nil
+		}
+		return nil, fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+
+	for encodedKey, resourcesJSON := range configMap.Data {
+		compositionKey, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode composition key %q: %w", encodedKey, err)
+		}
+		var resources map[string]ResourceData
+		if err := json.Unmarshal([]byte(resourcesJSON), &resources); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal resource data of composition %q: %w", compositionKey, err)
+		}
+		compositions[string(compositionKey)] = resources
+	}
+
+	c.log.Debug("Listed compositions in ConfigMap", "configmap", configMapName, "count", len(compositions))
+	return compositions, nil
+}
+
 // DeleteResource removes a specific resource's data from a composition
 func (c *ConfigMapStore) DeleteResource(ctx context.Context, clusterID, compositionKey, resourceKey string) error {
 	// Load all resources for this composition
@@ -173,8 +395,13 @@ func (c *ConfigMapStore) Purge(ctx context.Context, clusterID, compositionKey st
 	configMapName := c.getConfigMapName(clusterID)
 	encodedKey := c.encodeKey(compositionKey)
 
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
 	// Get the ConfigMap
-	configMap, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	configMap, err := client.CoreV1().ConfigMaps(c.namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			c.log.Debug("ConfigMap not found, nothing to purge", "configmap", configMapName)
@@ -190,19 +417,21 @@ func (c *ConfigMapStore) Purge(ctx context.Context, clusterID, compositionKey st
 
 	// If ConfigMap is now empty, delete it
 	if len(configMap.Data) == 0 {
-		err = c.client.CoreV1().ConfigMaps(c.namespace).Delete(ctx, configMapName, metav1.DeleteOptions{})
+		err = client.CoreV1().ConfigMaps(c.namespace).Delete(ctx, configMapName, metav1.DeleteOptions{})
 		if err != nil {
 			return fmt.Errorf("failed to delete ConfigMap: %w", err)
 		}
+		c.updateSnapshot(configMapName, nil)
 		c.log.Debug("Deleted empty ConfigMap", "configmap", configMapName)
 		return nil
 	}
 
 	// Update the ConfigMap
-	_, err = c.client.CoreV1().ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	_, err = client.CoreV1().ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update ConfigMap: %w", err)
 	}
+	c.updateSnapshot(configMapName, configMap)
 
 	c.log.Debug("Purged composition from ConfigMap", "composition-key", compositionKey)
 	return nil
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// RestoredDataContextKey is the pipeline context key the stored resource data is written to,
+// so that later functions in the pipeline can use restored values
+const RestoredDataContextKey = "fn.crossplane.io/external-name-backup"
+
+// restoredDataContext is the stored resource data passed to later functions in the pipeline
+type restoredDataContext struct {
+	CompositionKey string                  `json:"compositionKey"`
+	ClusterID      string                  `json:"clusterId"`
+	Resources      map[string]ResourceData `json:"resources"`
+	Restored       []string                `json:"restored"`
+}
+
+// setRestoredDataContext writes the stored resource data to the pipeline context
+func setRestoredDataContext(rsp *fnv1.RunFunctionResponse, c restoredDataContext) error {
+	if c.Resources == nil {
+		c.Resources = map[string]ResourceData{}
+	}
+	if c.Restored == nil {
+		c.Restored = []string{}
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal restored data")
+	}
+	s := &structpb.Struct{}
+	if err := s.UnmarshalJSON(b); err != nil {
+		return errors.Wrap(err, "cannot convert restored data")
+	}
+	response.SetContextKey(rsp, RestoredDataContextKey, structpb.NewStructValue(s))
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+)
+
+const (
+	// StandaloneKeyPrefix prefixes the composition keys of managed resources that aren't composed
+	StandaloneKeyPrefix = "standalone"
+
+	// RestoreWebhookPath is the path the restore admission webhook is served at
+	RestoreWebhookPath = "/restore"
+)
+
+// jsonPatchOp is an operation of the JSON patch the restore admission webhook responds with
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// standaloneController backs up the external names of managed resources that aren't composed, and
+// restores them into recreated managed resources through a mutating admission webhook
+type standaloneController struct {
+	f           *Function
+	log         logging.Logger
+	store       ResourceStore
+	clusterID   string
+	backupScope string
+	kinds       []schema.GroupKind
+
+	// mu serializes backups, so that concurrent informers don't race on the store
+	mu sync.Mutex
+	// backedUp is the resource data last backed up per key, so that updates that don't change
+	// the external name don't reach the store. Keys are removed when their resource is deleted.
+	backedUp map[string]ResourceData
+}
+
+// newStandaloneController returns a controller for the managed resource kinds
+func newStandaloneController(f *Function, store ResourceStore, clusterID, backupScope string, kinds []schema.GroupKind) *standaloneController {
+	return &standaloneController{
+		f:           f,
+		log:         f.log,
+		store:       store,
+		clusterID:   clusterID,
+		backupScope: backupScope,
+		kinds:       kinds,
+		backedUp:    make(map[string]ResourceData),
+	}
+}
+
+// standaloneKey returns the composition key of a managed resource that isn't composed:
+// standalone/<namespace or none>/<group>/<kind>/<name>. It leaves out the API version, so that
+// backups survive API version upgrades of the provider.
+func standaloneKey(u *unstructured.Unstructured) string {
+	namespace := u.GetNamespace()
+	if namespace == "" {
+		namespace = "none"
+	}
+	gk := u.GroupVersionKind().GroupKind()
+	return strings.Join([]string{StandaloneKeyPrefix, namespace, gk.Group, gk.Kind, u.GetName()}, "/")
+}
+
+// isComposed reports whether a managed resource is composed, in which case the function backs it up
+func isComposed(u *unstructured.Unstructured) bool {
+	return u.GetLabels()[CompositeLabel] != ""
+}
+
+// eligible reports whether the external name of a managed resource is backed up and restored,
+// by the same backup policy and backup scope rules as composed resources
+func (c *standaloneController) eligible(u *unstructured.Unstructured) (bool, error) {
+	if isComposed(u) || !slices.Contains(c.kinds, u.GroupVersionKind().GroupKind()) {
+		return false, nil
+	}
+	resource, err := structpb.NewStruct(u.Object)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot convert managed resource %q", u.GetName())
+	}
+	name := u.GetKind() + "/" + u.GetName()
+	policy := c.f.backupPolicy(u.GetAnnotations()[BackupPolicyAnnotation], nil, name, resource.GetFields())
+	return c.f.shouldProcessResourceWithPolicy(resource.GetFields(), name, c.backupScope, policy), nil
+}
+
+// backup backs up the external name of a watched managed resource
+func (c *standaloneController) backup(ctx context.Context, u *unstructured.Unstructured) error {
+	externalName := u.GetAnnotations()["crossplane.io/external-name"]
+	if externalName == "" || u.GetDeletionTimestamp() != nil {
+		return nil
+	}
+	ok, err := c.eligible(u)
+	if err != nil || !ok {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := standaloneKey(u)
+	data := ResourceData{ExternalName: externalName, ResourceName: u.GetName()}
+	if cached, ok := c.backedUp[key]; ok && cached == data {
+		return nil
+	}
+
+	stored, err := c.store.Load(ctx, c.clusterID, key)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load resource data of %q from store", key)
+	}
+	if stored[u.GetName()] != data {
+		if err := c.store.Save(ctx, c.clusterID, key, map[string]ResourceData{u.GetName(): data}); err != nil {
+			return errors.Wrapf(err, "failed to save resource data of %q to store", key)
+		}
+		c.log.Info("Backed up external name of managed resource", "key", key, "external-name", externalName)
+	}
+	c.backedUp[key] = data
+	return nil
+}
+
+// forget removes a deleted managed resource from the backed up resource data. Its stored data is
+// kept, so that it can be restored when the resource is recreated.
+func (c *standaloneController) forget(u *unstructured.Unstructured) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.backedUp, standaloneKey(u))
+}
+
+// restorePatch returns the JSON patch that restores the stored external name into a managed
+// resource being created, or nil if there is nothing to restore
+func (c *standaloneController) restorePatch(ctx context.Context, u *unstructured.Unstructured) ([]jsonPatchOp, error) {
+	// A generated name is unknown until the resource is created, and a set external name is kept
+	if u.GetName() == "" || u.GetAnnotations()["crossplane.io/external-name"] != "" {
+		return nil, nil
+	}
+	ok, err := c.eligible(u)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	key := standaloneKey(u)
+	stored, err := c.store.Load(ctx, c.clusterID, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load resource data of %q from store", key)
+	}
+	externalName := stored[u.GetName()].ExternalName
+	if externalName == "" {
+		return nil, nil
+	}
+
+	restored := map[string]string{
+		"crossplane.io/external-name":  externalName,
+		ExternalNameRestoredAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+	if u.GetAnnotations() == nil {
+		return []jsonPatchOp{{Op: "add", Path: "/metadata/annotations", Value: restored}}, nil
+	}
+	patch := make([]jsonPatchOp, 0, len(restored))
+	for _, k := range []string{"crossplane.io/external-name", ExternalNameRestoredAnnotation} {
+		// JSON pointer escaping, see RFC 6901
+		path := "/metadata/annotations/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+		patch = append(patch, jsonPatchOp{Op: "add", Path: path, Value: restored[k]})
+	}
+	return patch, nil
+}
+
+// ServeHTTP serves the restore admission webhook
+func (c *standaloneController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	review := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Request == nil {
+		http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = c.admit(r.Context(), review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		c.log.Info("Failed to write admission response", "error", err.Error())
+	}
+}
+
+// admit restores the stored external name into a managed resource being created. It denies the
+// creation when the store can't be read, since the resource would create a new external resource.
+func (c *standaloneController) admit(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	rsp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation != admissionv1.Create {
+		return rsp
+	}
+
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(req.Object.Raw); err != nil {
+		c.log.Info("Cannot parse admitted resource", "kind", req.Kind.Kind, "name", req.Name, "error", err.Error())
+		return rsp
+	}
+	// The namespace of a namespaced resource may only be set by the request
+	if u.GetNamespace() == "" {
+		u.SetNamespace(req.Namespace)
+	}
+
+	patch, err := c.restorePatch(ctx, u)
+	if err != nil {
+		c.log.Info("Denying managed resource, cannot restore its external name", "key", standaloneKey(u), "error", err.Error())
+		rsp.Allowed = false
+		rsp.Result = &metav1.Status{Code: http.StatusServiceUnavailable, Message: err.Error()}
+		return rsp
+	}
+	if patch == nil {
+		return rsp
+	}
+
+	b, err := json.Marshal(patch)
+	if err != nil {
+		c.log.Info("Cannot marshal restore patch", "key", standaloneKey(u), "error", err.Error())
+		return rsp
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	rsp.Patch = b
+	rsp.PatchType = &patchType
+	c.log.Info("Restored external name of managed resource", "key", standaloneKey(u))
+	return rsp
+}
+
+// watch backs up the external names of the managed resources until the context is done. Like the
+// function, it refuses to write under the default cluster ID unless that is allowed.
+func (c *standaloneController) watch(ctx context.Context, client dynamic.Interface, resources []schema.GroupVersionResource, resync time.Duration) error {
+	if c.clusterID == DefaultClusterID && !c.f.allowDefaultClusterID {
+		return errors.Errorf("refusing to back up managed resources under the %q cluster ID: set --cluster-id, or --allow-default-cluster-id", DefaultClusterID)
+	}
+
+	handle := func(obj any) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		if err := c.backup(ctx, u); err != nil {
+			c.log.Info("Failed to back up external name of managed resource", "key", standaloneKey(u), "error", err.Error())
+		}
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, resync)
+	for _, r := range resources {
+		_, err := factory.ForResource(r).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    handle,
+			UpdateFunc: func(_, obj any) { handle(obj) },
+			DeleteFunc: func(obj any) {
+				if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = d.Obj
+				}
+				if u, ok := obj.(*unstructured.Unstructured); ok {
+					c.forget(u)
+				}
+			},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "cannot watch %s", r)
+		}
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	for r, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("failed to sync informer of %s", r)
+		}
+	}
+	c.log.Info("Watching managed resources", "resources", len(resources), "cluster-id", c.clusterID)
+	<-ctx.Done()
+	return nil
+}
+
+// newRESTMapper returns a REST mapper that discovers the API resources of the cluster
+func newRESTMapper(config *rest.Config) (meta.RESTMapper, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create discovery client")
+	}
+	return restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)), nil
+}
+
+// mapKinds resolves kinds, given as '<kind>.<version>.<group>', to their group kinds and resources
+func mapKinds(mapper meta.RESTMapper, args []string) ([]schema.GroupKind, []schema.GroupVersionResource, error) {
+	kinds := make([]schema.GroupKind, 0, len(args))
+	resources := make([]schema.GroupVersionResource, 0, len(args))
+	for _, arg := range args {
+		gvk, _ := schema.ParseKindArg(arg)
+		if gvk == nil {
+			return nil, nil, errors.Errorf("invalid kind %q: expected <kind>.<version>.<group>", arg)
+		}
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot find kind %q", arg)
+		}
+		kinds = append(kinds, gvk.GroupKind())
+		resources = append(resources, mapping.Resource)
+	}
+	return kinds, resources, nil
+}
@@ -138,9 +138,47 @@ func (d *DynamoDBStore) Load(ctx context.Context, clusterID, compositionKey stri
 		return make(map[string]ResourceData), nil
 	}
 
+	resources := decodeResources(result.Item)
+
+	d.log.Info("Loaded resource data from DynamoDB",
+		"cluster-id", clusterID,
+		"composition-key", compositionKey,
+		"count", len(resources))
+
+	return resources, nil
+}
+
+// ListCompositions retrieves the resource data of all compositions of a cluster from DynamoDB
+func (d *DynamoDBStore) ListCompositions(ctx context.Context, clusterID string) (map[string]map[string]ResourceData, error) {
+	compositions := make(map[string]map[string]ResourceData)
+	paginator := dynamodb.NewQueryPaginator(d.client, &dynamodb.QueryInput{
+		TableName:              aws.String(d.tableName),
+		KeyConditionExpression: aws.String("cluster_id = :cluster_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":cluster_id": &types.AttributeValueMemberS{Value: clusterID},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query DynamoDB: %w", err)
+		}
+		for _, item := range page.Items {
+			if key, ok := item["composition_key"].(*types.AttributeValueMemberS); ok {
+				compositions[key.Value] = decodeResources(item)
+			}
+		}
+	}
+
+	d.log.Info("Listed compositions in DynamoDB", "cluster-id", clusterID, "count", len(compositions))
+	return compositions, nil
+}
+
+// decodeResources decodes the resources attribute of a DynamoDB item
+func decodeResources(item map[string]types.AttributeValue) map[string]ResourceData {
 	resources := make(map[string]ResourceData)
 
-	if resourcesAttr, ok := result.Item["resources"].(*types.AttributeValueMemberM); ok {
+	if resourcesAttr, ok := item["resources"].(*types.AttributeValueMemberM); ok {
 		for resourceKey, resourceAttr := range resourcesAttr.Value {
 			data := ResourceData{}
 			if resourceMap, ok := resourceAttr.(*types.AttributeValueMemberM); ok {
@@ -154,13 +192,7 @@ func (d *DynamoDBStore) Load(ctx context.Context, clusterID, compositionKey stri
 			resources[resourceKey] = data
 		}
 	}
-
-	d.log.Info("Loaded resource data from DynamoDB",
-		"cluster-id", clusterID,
-		"composition-key", compositionKey,
-		"count", len(resources))
-
-	return resources, nil
+	return resources
 }
 
 // Purge removes all external names for a composition from DynamoDB
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// Export formats
+const (
+	// ExportFormatKustomize renders a kustomize component with a patch per composite
+	ExportFormatKustomize = "kustomize"
+	// ExportFormatAnnotations renders a YAML stream of the composites' annotations
+	ExportFormatAnnotations = "annotations"
+)
+
+// exportedComposite is a composite whose stored external names are exported
+type exportedComposite struct {
+	compositionKey string
+	parts          compositionKeyParts
+	externalNames  map[string]string
+}
+
+// exportComposites returns the composites with stored external names, sorted by composition key.
+// The composites of claims are returned separately, because their generated names aren't in Git
+// and patches can't target them. The sorted keys that don't identify a composite, like standalone
+// and overridden keys, are returned last.
+func exportComposites(compositions map[string]map[string]ResourceData) (composites, claimed []exportedComposite, skipped []string) {
+	for _, key := range slices.Sorted(maps.Keys(compositions)) {
+		if strings.HasSuffix(key, tombstoneKeySuffix) {
+			continue
+		}
+
+		externalNames := make(map[string]string)
+		for name, data := range compositions[key] {
+			if data.ExternalName != "" {
+				externalNames[name] = data.ExternalName
+			}
+		}
+		if len(externalNames) == 0 {
+			continue
+		}
+
+		// A composite's key is {namespace}/{claimName}/{group}/{version}/{kind}/{name}
+		p := strings.Split(key, "/")
+		if len(p) != 6 || slices.Contains(p, "") {
+			skipped = append(skipped, key)
+			continue
+		}
+		c := exportedComposite{
+			compositionKey: key,
+			parts:          compositionKeyParts{Namespace: p[0], ClaimName: p[1], APIVersion: p[2] + "/" + p[3], Kind: p[4], Name: p[5]},
+			externalNames:  externalNames,
+		}
+		if c.parts.ClaimName != "none" {
+			claimed = append(claimed, c)
+			continue
+		}
+		composites = append(composites, c)
+	}
+	return composites, claimed, skipped
+}
+
+// annotation returns the value of the composite's external names annotation
+func (c exportedComposite) annotation() (string, error) {
+	names, err := json.Marshal(c.externalNames)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot marshal external names of composition %q", c.compositionKey)
+	}
+	return string(names), nil
+}
+
+// patch returns the strategic merge patch that sets the composite's external names annotation.
+// Composites with a namespace are namespaced.
+func (c exportedComposite) patch() (map[string]any, error) {
+	names, err := c.annotation()
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]any{
+		"name":        c.parts.Name,
+		"annotations": map[string]any{ExternalNamesAnnotation: names},
+	}
+	if c.parts.Namespace != "none" {
+		metadata["namespace"] = c.parts.Namespace
+	}
+	return map[string]any{
+		"apiVersion": c.parts.APIVersion,
+		"kind":       c.parts.Kind,
+		"metadata":   metadata,
+	}, nil
+}
+
+// renderExport writes the external names of the composites in the export format
+func renderExport(w io.Writer, format, clusterID string, composites []exportedComposite) error {
+	fmt.Fprintf(w, "# External names stored for cluster %q, exported by function-external-name-backup-restore\n", clusterID)
+
+	switch format {
+	case ExportFormatKustomize:
+		patches := make([]map[string]any, 0, len(composites))
+		for _, c := range composites {
+			p, err := c.patch()
+			if err != nil {
+				return err
+			}
+			b, err := yaml.Marshal(p)
+			if err != nil {
+				return errors.Wrapf(err, "cannot render patch of composition %q", c.compositionKey)
+			}
+			patches = append(patches, map[string]any{"patch": string(b)})
+		}
+		b, err := yaml.Marshal(map[string]any{
+			"apiVersion": "kustomize.config.k8s.io/v1alpha1",
+			"kind":       "Component",
+			"patches":    patches,
+		})
+		if err != nil {
+			return errors.Wrap(err, "cannot render kustomize component")
+		}
+		_, err = w.Write(b)
+		return err
+
+	case ExportFormatAnnotations:
+		for _, c := range composites {
+			p, err := c.patch()
+			if err != nil {
+				return err
+			}
+			b, err := yaml.Marshal(p)
+			if err != nil {
+				return errors.Wrapf(err, "cannot render annotations of composition %q", c.compositionKey)
+			}
+			fmt.Fprintln(w, "---")
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return errors.Errorf("unsupported export format %q (supported formats: '%s', '%s')", format, ExportFormatKustomize, ExportFormatAnnotations)
+	}
+}
+
+// getExternalNamesAnnotation parses the external names annotation of a composite, keyed by pipeline resource name
+func getExternalNamesAnnotation(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	names := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &names); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s annotation: expected a JSON object of resource names to external names", ExternalNamesAnnotation)
+	}
+	return names, nil
+}
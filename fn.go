@@ -3,10 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
@@ -27,6 +31,10 @@ const (
 	// ExternalNameDeletedAnnotation tracks when the external name was deleted with timestamp
 	ExternalNameDeletedAnnotation = "fn.crossplane.io/external-name-deleted"
 
+	// ExternalNameDeletePendingAnnotation tracks a deletion from the store that failed, with the timestamp
+	// of the first failure. The deletion is retried on every reconcile until it succeeds.
+	ExternalNameDeletePendingAnnotation = "fn.crossplane.io/external-name-delete-pending"
+
 	// ExternalNameRestoredAnnotation tracks when the external name was restored with timestamp
 	ExternalNameRestoredAnnotation = "fn.crossplane.io/external-name-restored"
 
@@ -42,11 +50,24 @@ const (
 	// EnableExternalStoreAnnotation on XR enables external store loading and storing
 	EnableExternalStoreAnnotation = "fn.crossplane.io/enable-external-store"
 
-	// PurgeExternalStoreAnnotation on XR purges all stored external names for this composition
+	// PurgeExternalStoreAnnotation on XR purges all stored external names for this composition.
+	// The value must be the XR's UID or generation, so that each purge is performed only once.
 	PurgeExternalStoreAnnotation = "fn.crossplane.io/purge-external-store"
+	// UndeleteExternalStoreAnnotation on XR restores purged data for this composition from its tombstone
+	UndeleteExternalStoreAnnotation = "fn.crossplane.io/undelete-external-store"
+	// PurgeConfirmationAnnotation on XR carries the confirmation token required by the input's purge policy
+	PurgeConfirmationAnnotation = "fn.crossplane.io/purge-confirmation"
 
 	// ClusterIDAnnotation specifies the cluster ID for external name storage
 	ClusterIDAnnotation = "fn.crossplane.io/cluster-id"
+	// ClusterIDSourceAnnotation specifies where to discover the cluster ID from when no cluster ID is set,
+	// either "kube-system-uid" or "configmap:<namespace>/<name>"
+	ClusterIDSourceAnnotation = "fn.crossplane.io/cluster-id-source"
+	// AllowDefaultClusterIDAnnotation when set to "true" permits writes under the "default" cluster ID
+	AllowDefaultClusterIDAnnotation = "fn.crossplane.io/allow-default-cluster-id"
+	// RestoreClusterIDAnnotation specifies a different cluster ID to restore from when
+	// the cluster ID has no data yet, e.g. when a cluster is rebuilt under a new ID
+	RestoreClusterIDAnnotation = "fn.crossplane.io/restore-cluster-id"
 	// StoreTypeAnnotation specifies the type of external store to use
 	StoreTypeAnnotation = "fn.crossplane.io/store-type"
 	// DynamoDBTableAnnotation specifies the DynamoDB table name
@@ -59,6 +80,13 @@ const (
 	// ConfigMapNamespaceAnnotation specifies the namespace for ConfigMap store
 	ConfigMapNamespaceAnnotation = "fn.crossplane.io/configmap-namespace"
 
+	// ConfigMapReadsAnnotation specifies how the ConfigMap store is read: from the Kubernetes API,
+	// or from required resources supplied by Crossplane
+	ConfigMapReadsAnnotation = "fn.crossplane.io/configmap-reads"
+
+	// GitBranchAnnotation specifies the branch of the git store
+	GitBranchAnnotation = "fn.crossplane.io/git-branch"
+
 	// OverrideKindAnnotation allows overriding the XR kind used in composition key lookup
 	// This is useful for migrations where the XR kind changes between versions
 	OverrideKindAnnotation = "fn.crossplane.io/override-kind"
@@ -67,11 +95,64 @@ const (
 	// This is useful for migrations from cluster-scoped to namespaced XRs
 	OverrideNamespaceAnnotation = "fn.crossplane.io/override-namespace"
 
+	// OverrideAPIVersionAnnotation allows overriding the XR apiVersion used in composition key lookup
+	// This is useful for migrations where the XRD group or version changes
+	OverrideAPIVersionAnnotation = "fn.crossplane.io/override-api-version"
+
+	// OverrideNameAnnotation allows overriding the XR name used in composition key lookup
+	// This is useful when XRs are renamed
+	OverrideNameAnnotation = "fn.crossplane.io/override-name"
+
+	// OverrideClaimNameAnnotation allows overriding the claim name used in composition key lookup
+	// This is useful for migrations from claims to directly created namespaced XRs
+	OverrideClaimNameAnnotation = "fn.crossplane.io/override-claim-name"
+
+	// ResourceRenamesAnnotation maps stored resource names to renamed pipeline resource names
+	// as a comma-separated list of oldName=newName pairs, e.g. "vpc=network-vpc,subnet-*=network-subnet-*"
+	ResourceRenamesAnnotation = "fn.crossplane.io/resource-renames"
+
+	// PurgeResourcesAnnotation on XR purges the stored data of selected composed resources and
+	// excludes them from restore and backup while set, as a comma-separated list of
+	// resource names or "kind:<Kind>" entries, e.g. "bucket,subnet-*,kind:Bucket"
+	PurgeResourcesAnnotation = "fn.crossplane.io/purge-resources"
+
+	// SkipRestoreResourcesAnnotation on XR skips restoring selected composed resources, using
+	// the same format as PurgeResourcesAnnotation. Their stored data is kept until a new
+	// external name is backed up.
+	SkipRestoreResourcesAnnotation = "fn.crossplane.io/skip-restore-resources"
+
+	// OverrideCompositionKeyAnnotation replaces the whole composition key used for lookup and storage,
+	// bypassing key construction and all other override annotations
+	OverrideCompositionKeyAnnotation = "fn.crossplane.io/override-composition-key"
+
 	// RequireRestoreAnnotation when set to "true" will fail the function if no external names
 	// can be restored from the store. This prevents accidental resource creation during migrations
 	// when override annotations are misconfigured.
 	RequireRestoreAnnotation = "fn.crossplane.io/restore-only"
 
+	// BackupPolicyAnnotation on a composed resource forces it to be backed up ("Always") or
+	// never backed up ("Never"), regardless of the backup scope and the input's selectors
+	BackupPolicyAnnotation = "fn.crossplane.io/backup-policy"
+
+	// RestoreOnlyAllowMissingAnnotation lists desired resources that may lack stored data in restore-only
+	// mode, as a comma-separated list of resource names or "kind:<Kind>" entries, e.g. "monitoring-*"
+	RestoreOnlyAllowMissingAnnotation = "fn.crossplane.io/restore-only-allow-missing"
+
+	// RestoreOnlyModeAnnotation selects which desired resources must have stored data in
+	// restore-only mode: "all" (default) or "generated-names"
+	RestoreOnlyModeAnnotation = "fn.crossplane.io/restore-only-mode"
+
+	// BackupOnlyAnnotation when set to "true" enables backup-only mode: resource data is backed up,
+	// but stored values are never restored into desired resources. This is the inverse of restore-only.
+	BackupOnlyAnnotation = "fn.crossplane.io/backup-only"
+
+	// ExternalNamesAnnotation on XR holds external names committed to Git, as a JSON object of
+	// resource names to external names. They take precedence over the store when restoring.
+	ExternalNamesAnnotation = "fn.crossplane.io/external-names"
+
+	// BackupOnlyConditionType is the XR condition reporting whether backup-only mode is active
+	BackupOnlyConditionType = "BackupOnly"
+
 	// BackupScopeOrphaned processes only orphaned resources
 	BackupScopeOrphaned = "orphaned"
 	// BackupScopeAll processes all resources regardless of policy
@@ -88,88 +169,131 @@ type Function struct {
 	fnv1.UnimplementedFunctionRunnerServiceServer
 
 	log logging.Logger
+
+	// configFlags is the configuration set by the function's flags, keyed by setting
+	configFlags map[string]string
+	// loadConfigMap loads the configuration set by the function's ConfigMap
+	loadConfigMap ConfigLoader
+	// allowDefaultClusterID permits writes under DefaultClusterID
+	allowDefaultClusterID bool
+
+	discoverClusterID ClusterIDDiscoverer
+	clusterIDsMu      sync.Mutex
+	clusterIDs        map[string]string // cluster ID source -> discovered cluster ID
+	clusterIDsFlight  singleflight.Group
+}
+
+// A FunctionOption configures a Function
+type FunctionOption func(f *Function)
+
+// WithConfigFlags sets the configuration set by the function's flags, keyed by setting
+func WithConfigFlags(values map[string]string) FunctionOption {
+	return func(f *Function) {
+		f.configFlags = values
+	}
+}
+
+// WithConfigMap sets the loader of the configuration set by the function's ConfigMap
+func WithConfigMap(load ConfigLoader) FunctionOption {
+	return func(f *Function) {
+		f.loadConfigMap = load
+	}
+}
+
+// WithAllowDefaultClusterID permits writes under the default cluster ID
+func WithAllowDefaultClusterID(allow bool) FunctionOption {
+	return func(f *Function) {
+		f.allowDefaultClusterID = allow
+	}
+}
+
+// WithClusterIDDiscoverer sets how cluster IDs are discovered from a cluster ID source
+func WithClusterIDDiscoverer(d ClusterIDDiscoverer) FunctionOption {
+	return func(f *Function) {
+		f.discoverClusterID = d
+	}
 }
 
 // NewFunction creates a new Function
-func NewFunction(_ context.Context, log logging.Logger) *Function {
-	return &Function{
-		log: log,
+func NewFunction(_ context.Context, log logging.Logger, opts ...FunctionOption) *Function {
+	f := &Function{
+		log:               log,
+		discoverClusterID: discoverClusterID,
+		clusterIDs:        make(map[string]string),
 	}
+	for _, o := range opts {
+		o(f)
+	}
+	return f
 }
 
 // FunctionConfig holds all configuration for the function
 type FunctionConfig struct {
 	ClusterID          string
+	ClusterIDSource    string
+	RestoreClusterID   string
 	StoreType          string
 	DynamoDBTable      string
 	DynamoDBRegion     string
 	ConfigMapNamespace string
+	ConfigMapReads     string
 	BackupScope        string
-}
+	GitRepository      string
+	GitBranch          string
+	HTTPEndpoint       string
+	PluginAddress      string
 
-// getConfigFromAnnotations extracts configuration from XR annotations with defaults
-//
-//nolint:gocyclo // complexity of 11 is acceptable for this config extraction function
-func getConfigFromAnnotations(req *fnv1.RunFunctionRequest, log logging.Logger) *FunctionConfig {
-	config := &FunctionConfig{
-		ClusterID:          "default",
-		StoreType:          "awsdynamodb",
-		DynamoDBTable:      "external-name-backup",
-		DynamoDBRegion:     "us-west-2",
-		ConfigMapNamespace: "crossplane-system",
-		BackupScope:        BackupScopeOrphaned,
-	}
+	// Sources holds the configuration source of each setting
+	Sources map[string]string
+}
 
+// getCompositeConfigAnnotation gets a configuration annotation from the observed composite, falling back to the desired composite
+func getCompositeConfigAnnotation(req *fnv1.RunFunctionRequest, annotation string) string {
 	// Check observed composite first for XR annotations (the source of truth),
 	// then fall back to desired composite for each annotation if not found.
 	// This is important because previous pipeline steps may create a desired composite
 	// without preserving the original XR annotations.
-	observedComposite := req.GetObserved().GetComposite().GetResource()
-	desiredComposite := req.GetDesired().GetComposite().GetResource()
-
-	// Helper to get annotation from observed first, then desired as fallback
-	getConfigAnnotation := func(annotation string) string {
-		if observedComposite != nil {
-			if val := getAnnotationValue(observedComposite, annotation); val != "" {
-				return val
-			}
+	if observedComposite := req.GetObserved().GetComposite().GetResource(); observedComposite != nil {
+		if val := getAnnotationValue(observedComposite, annotation); val != "" {
+			return val
 		}
-		if desiredComposite != nil {
-			if val := getAnnotationValue(desiredComposite, annotation); val != "" {
-				return val
-			}
+	}
+	if desiredComposite := req.GetDesired().GetComposite().GetResource(); desiredComposite != nil {
+		if val := getAnnotationValue(desiredComposite, annotation); val != "" {
+			return val
 		}
-		return ""
 	}
+	return ""
+}
 
-	if clusterID := getConfigAnnotation(ClusterIDAnnotation); clusterID != "" {
-		config.ClusterID = clusterID
-	}
-	if storeType := getConfigAnnotation(StoreTypeAnnotation); storeType != "" {
-		config.StoreType = storeType
-	}
-	if dynamoDBTable := getConfigAnnotation(DynamoDBTableAnnotation); dynamoDBTable != "" {
-		config.DynamoDBTable = dynamoDBTable
-	}
-	if dynamoDBRegion := getConfigAnnotation(DynamoDBRegionAnnotation); dynamoDBRegion != "" {
-		config.DynamoDBRegion = dynamoDBRegion
+// getStoreCredentials retrieves the credentials of a store type from the request (returns nil if not found)
+func getStoreCredentials(req *fnv1.RunFunctionRequest, storeType string) (map[string]string, error) {
+	switch storeType {
+	case "awsdynamodb":
+		creds, err := getAWSCredentials(req)
+		return creds, errors.Wrap(err, "failed to parse AWS credentials")
+	case "git":
+		return getGitCredentials(req), nil
+	case "http":
+		return getHTTPCredentials(req), nil
+	case "plugin":
+		return getPluginCredentials(req), nil
+	default:
+		return nil, nil
 	}
-	if backupScope := getConfigAnnotation(BackupScopeAnnotation); backupScope != "" {
-		config.BackupScope = backupScope
+}
+
+// getCredentialData retrieves the data of the named function credentials as strings (returns nil if not found)
+func getCredentialData(req *fnv1.RunFunctionRequest, name string) map[string]string {
+	data := req.GetCredentials()[name].GetCredentialData().GetData()
+	if len(data) == 0 {
+		return nil
 	}
-	if configMapNamespace := getConfigAnnotation(ConfigMapNamespaceAnnotation); configMapNamespace != "" {
-		config.ConfigMapNamespace = configMapNamespace
+	creds := make(map[string]string, len(data))
+	for k, v := range data {
+		creds[k] = string(v)
 	}
-
-	log.Info("Configuration loaded from XR annotations",
-		"cluster-id", config.ClusterID,
-		"store-type", config.StoreType,
-		"dynamodb-table", config.DynamoDBTable,
-		"dynamodb-region", config.DynamoDBRegion,
-		"configmap-namespace", config.ConfigMapNamespace,
-		"backup-scope", config.BackupScope)
-
-	return config
+	return creds
 }
 
 // getAWSCredentials retrieves AWS credentials from the request (returns nil if not found)
@@ -290,6 +414,27 @@ func getAnnotationValue(composite *structpb.Struct, annotation string) string {
 	return ""
 }
 
+// getCompositeAnnotation gets an annotation value from the desired composite, falling back to the observed composite
+func getCompositeAnnotation(req *fnv1.RunFunctionRequest, annotation string) string {
+	if desiredComposite := req.GetDesired().GetComposite().GetResource(); desiredComposite != nil {
+		if val := getAnnotationValue(desiredComposite, annotation); val != "" {
+			return val
+		}
+	}
+	if observedComposite := req.GetObserved().GetComposite().GetResource(); observedComposite != nil {
+		return getAnnotationValue(observedComposite, annotation)
+	}
+	return ""
+}
+
+// removeAnnotations removes annotations from a resource
+func removeAnnotations(resource *structpb.Struct, annotations ...string) {
+	fields := resource.GetFields()["metadata"].GetStructValue().GetFields()["annotations"].GetStructValue().GetFields()
+	for _, a := range annotations {
+		delete(fields, a)
+	}
+}
+
 // getMetadataName gets the metadata.name from a resource struct
 func getMetadataName(resource *structpb.Struct) string {
 	if fields := resource.GetFields(); fields != nil {
@@ -346,10 +491,15 @@ func getAnnotationValueFromResource(req *fnv1.RunFunctionRequest, resourceName s
 	return ""
 }
 
+// isTrue reports whether an annotation value enables a feature
+func isTrue(value string) bool {
+	return value == "true" || value == "yes" || value == "1"
+}
+
 // checkEnableAnnotation checks for enable annotation in a composite resource
 func checkEnableAnnotation(composite *structpb.Struct, log logging.Logger, source string) bool {
 	enableValue := getAnnotationValue(composite, EnableExternalStoreAnnotation)
-	if enableValue == "true" || enableValue == "yes" || enableValue == "1" {
+	if isTrue(enableValue) {
 		log.Info("External store operations enabled by XR annotation",
 			"source", source,
 			"annotation", EnableExternalStoreAnnotation,
@@ -381,7 +531,7 @@ func shouldPurgeExternalStore(req *fnv1.RunFunctionRequest, log logging.Logger)
 // checkPurgeAnnotation checks for purge annotation in a composite resource
 func checkPurgeAnnotation(composite *structpb.Struct, log logging.Logger, source string) bool {
 	purgeValue := getAnnotationValue(composite, PurgeExternalStoreAnnotation)
-	if purgeValue == "true" || purgeValue == "yes" || purgeValue == "1" {
+	if purgeValue != "" {
 		log.Info("External store purge requested by XR annotation",
 			"source", source,
 			"annotation", PurgeExternalStoreAnnotation,
@@ -410,6 +560,73 @@ func shouldRequireRestore(req *fnv1.RunFunctionRequest) bool {
 	return false
 }
 
+// reportStatus writes the backup summary to the XR status and sets the BackupHealthy and RestoreComplete conditions
+func (f *Function) reportStatus(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, report *runReport, statusField string, requireRestore, backupOnly, storeWritesAllowed bool) {
+	if statusField != "" {
+		// Keep the last backup time of earlier runs when nothing was backed up
+		if report.LastBackupTime == "" {
+			report.LastBackupTime = getFieldValue(req.GetObserved().GetComposite().GetResource(), statusField+".lastBackupTime").GetStringValue()
+		}
+		summary, err := report.summary()
+		if err != nil {
+			f.log.Info("Failed to build backup summary", "error", err.Error())
+		} else {
+			setDesiredCompositeField(rsp, statusField, structpb.NewStructValue(summary))
+		}
+	}
+
+	pendingDeletes := report.withState(ResourceStateDeletePending)
+	switch {
+	case len(pendingDeletes) > 0:
+		response.ConditionFalse(rsp, BackupHealthyConditionType, "DeleteFailed").
+			WithMessage("Failed to delete stored data for resources, retrying on the next reconcile: " + strings.Join(pendingDeletes, ", ")).
+			TargetCompositeAndClaim()
+	case report.purgeFailed:
+		response.ConditionFalse(rsp, BackupHealthyConditionType, "PurgeFailed").
+			WithMessage("Failed to purge the empty composition from the store, retrying on the next reconcile").
+			TargetCompositeAndClaim()
+	case requireRestore:
+		response.ConditionTrue(rsp, BackupHealthyConditionType, "BackupDisabled").
+			WithMessage("Backup is disabled in restore-only mode").
+			TargetCompositeAndClaim()
+	case !storeWritesAllowed:
+		response.ConditionFalse(rsp, BackupHealthyConditionType, "WritesRefused").
+			WithMessage("No cluster ID is configured, resource data isn't backed up").
+			TargetCompositeAndClaim()
+	default:
+		response.ConditionTrue(rsp, BackupHealthyConditionType, "Available").
+			WithMessage("Resource data is backed up").
+			TargetCompositeAndClaim()
+	}
+
+	missing := report.withState(ResourceStateMissing)
+	switch {
+	case backupOnly:
+		response.ConditionUnknown(rsp, RestoreCompleteConditionType, "RestoreDisabled").
+			WithMessage("Restore is disabled in backup-only mode").
+			TargetCompositeAndClaim()
+	case len(missing) > 0:
+		response.ConditionFalse(rsp, RestoreCompleteConditionType, "ResourcesMissing").
+			WithMessage("No stored data for resources: " + strings.Join(missing, ", ")).
+			TargetCompositeAndClaim()
+	default:
+		response.ConditionTrue(rsp, RestoreCompleteConditionType, "Restored").
+			WithMessage("All resources with stored data are restored").
+			TargetCompositeAndClaim()
+	}
+}
+
+// hasCompositeCondition checks whether the observed composite has a condition of the given type
+func hasCompositeCondition(req *fnv1.RunFunctionRequest, conditionType string) bool {
+	status := req.GetObserved().GetComposite().GetResource().GetFields()["status"].GetStructValue()
+	for _, c := range status.GetFields()["conditions"].GetListValue().GetValues() {
+		if c.GetStructValue().GetFields()["type"].GetStringValue() == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
 // mergeObservedAnnotations ensures desired resource has annotation structure and merges observed annotations
 //
 //nolint:gocyclo // complex annotation merging logic
@@ -507,6 +724,8 @@ func (f *Function) removeTrackingAnnotationsFromObserved(req *fnv1.RunFunctionRe
 									// Remove resource name tracking annotations
 									delete(fields, StoredResourceNameAnnotation)
 									delete(fields, ResourceNameStoredAnnotation)
+									// Remove the pending deletion
+									delete(fields, ExternalNameDeletePendingAnnotation)
 								}
 							}
 						}
@@ -626,6 +845,20 @@ func (f *Function) shouldProcessResource(fields map[string]*structpb.Value, reso
 	return true
 }
 
+// shouldProcessResourceWithPolicy checks if a resource should be processed, letting a forced backup policy override the backup scope
+func (f *Function) shouldProcessResourceWithPolicy(fields map[string]*structpb.Value, resourceName, backupScope, backupPolicy string) bool {
+	switch backupPolicy {
+	case BackupPolicyAlways:
+		f.log.Info("Processing resource with backup policy Always", "resource", resourceName)
+		return true
+	case BackupPolicyNever:
+		f.log.Info("Skipping resource with backup policy Never", "resource", resourceName)
+		return false
+	default:
+		return f.shouldProcessResource(fields, resourceName, backupScope)
+	}
+}
+
 // RunFunction runs the Function.
 //
 //nolint:gocyclo // main function with complex orchestration logic
@@ -634,17 +867,23 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 
 	rsp := response.To(req, response.DefaultTTL)
 
+	// Parse function input
+	in := &v1beta1.Input{}
+	if err := request.GetInput(req, in); err != nil {
+		response.Fatal(rsp, errors.Wrapf(err, "cannot get Function input from %T", req))
+		return rsp, nil
+	}
+
+	// Operations have no composite, they sweep the managed resources selected by the input
+	if in.Sweep != nil {
+		f.runSweep(ctx, req, rsp, in)
+		return rsp, nil
+	}
+
 	// Check if external store operations should be enabled
 	if !shouldEnableExternalStore(req, f.log) {
 		f.log.Info("Skipping all external store operations - not enabled by XR annotation")
 
-		// Parse function input (for consistency)
-		in := &v1beta1.Input{}
-		if err := request.GetInput(req, in); err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "cannot get Function input from %T", req))
-			return rsp, nil
-		}
-
 		response.Normalf(rsp, "Processed %d desired and %d observed resources (external store disabled)",
 			len(req.GetDesired().GetResources()),
 			len(req.GetObserved().GetResources()))
@@ -655,214 +894,461 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		return rsp, nil
 	}
 
-	// Get configuration from XR annotations
-	config := getConfigFromAnnotations(req, f.log)
+	statusField, err := getStatusField(in)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
 
-	// Get AWS credentials for DynamoDB store (optional - will fallback to default credential chain)
-	var awsCreds map[string]string
-	var err error
-	if config.StoreType == "awsdynamodb" {
-		awsCreds, err = getAWSCredentials(req)
-		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "failed to parse AWS credentials"))
+	// Layer the configuration from defaults, flags, ConfigMap, environment, input and XR annotations
+	config, err := f.getConfig(req, in)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+
+	// Pin store settings from the input policy before the store is selected
+	if err := applyStorePolicy(req, in.Policy, config); err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+
+	// Restore-only and backup-only are opposite modes and can't be combined
+	requireRestore := shouldRequireRestore(req)
+	backupOnly := isTrue(getCompositeConfigAnnotation(req, BackupOnlyAnnotation))
+	if requireRestore && backupOnly {
+		response.Fatal(rsp, errors.Errorf("cannot set both %s and %s", RequireRestoreAnnotation, BackupOnlyAnnotation))
+		return rsp, nil
+	}
+	var restoreRequirement *restoreOnlyRequirement
+	if requireRestore {
+		if restoreRequirement, err = getRestoreOnlyRequirement(req, in); err != nil {
+			response.Fatal(rsp, err)
 			return rsp, nil
 		}
 	}
 
-	// Initialize external store based on configuration
-	var store ExternalNameStore
+	// Resolve the cluster ID: an explicit cluster ID wins, then discovery, then the default
+	if err := f.completeClusterID(ctx, config); err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
 
-	switch config.StoreType {
-	case "awsdynamodb":
-		store, err = NewDynamoDBStore(ctx, f.log, config.DynamoDBTable, config.DynamoDBRegion, awsCreds)
+	// Report the effective configuration, so the precedence of the configuration layers is visible
+	f.log.Info("Effective configuration", "config", config.describe())
+	response.Normalf(rsp, "Effective configuration: %s", config.describe())
+
+	// Refuse to write under the default cluster ID unless explicitly allowed, because every
+	// cluster that forgets to configure its identity would share the same data in the store
+	storeWritesAllowed := config.ClusterID != DefaultClusterID || f.allowDefaultClusterID ||
+		isTrue(getCompositeAnnotation(req, AllowDefaultClusterIDAnnotation))
+	if !storeWritesAllowed {
+		f.log.Info("Refusing to write to store under the default cluster ID", "cluster-id", config.ClusterID)
+		response.Warning(rsp, errors.Errorf(
+			"refusing to write to the external store under the %q cluster ID: set the %s or %s annotation, or allow it with %s",
+			DefaultClusterID, ClusterIDAnnotation, ClusterIDSourceAnnotation, AllowDefaultClusterIDAnnotation)).
+			TargetCompositeAndClaim()
+	}
+
+	// Get the store credentials (optional - stores fall back to their default credentials)
+	storeCreds, err := getStoreCredentials(req, config.StoreType)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+
+	// Initialize external store based on configuration
+	var store ResourceStore
+	switch {
+	case config.StoreType == "k8sconfigmap" && config.ConfigMapReads == ConfigMapReadsRequiredResources:
+		// Read the store ConfigMaps from required resources, so restores need no RBAC. Crossplane
+		// calls the function again once it has fetched them.
+		clusterIDs := storeClusterIDs(config, in)
+		requireStoreConfigMaps(rsp, clusterIDs)
+		snapshot, supplied, err := getStoreConfigMaps(req, config.ConfigMapNamespace, clusterIDs)
 		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "failed to initialize DynamoDB store"))
+			response.Fatal(rsp, errors.Wrapf(err, "failed to read store ConfigMaps from required resources"))
 			return rsp, nil
 		}
-	case "mock":
-		store, err = NewMockStore(ctx, f.log)
-		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "failed to initialize Mock store"))
+		if !supplied {
+			f.log.Info("Requesting store ConfigMaps as required resources", "cluster-ids", clusterIDs)
 			return rsp, nil
 		}
-	case "k8sconfigmap":
-		store, err = NewConfigMapStore(ctx, f.log, config.ConfigMapNamespace)
+		store = NewConfigMapSnapshotStore(f.log, config.ConfigMapNamespace, snapshot)
+	case config.ConfigMapReads != ConfigMapReadsAPI && config.ConfigMapReads != ConfigMapReadsRequiredResources:
+		response.Fatal(rsp, errors.Errorf("unsupported %s %q (supported values: '%s', '%s')",
+			ConfigConfigMapReads, config.ConfigMapReads, ConfigMapReadsAPI, ConfigMapReadsRequiredResources))
+		return rsp, nil
+	default:
+		store, err = newStore(ctx, f.log, config, storeCreds)
 		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "failed to initialize ConfigMap store"))
+			response.Fatal(rsp, err)
 			return rsp, nil
 		}
-
-	default:
-		response.Fatal(rsp, errors.Errorf("unsupported external store type: %s (supported types: 'awsdynamodb', 'mock', 'k8sconfigmap')", config.StoreType))
-		return rsp, nil
 	}
 
 	clusterID := config.ClusterID
 	backupScope := config.BackupScope
-	f.log.Info("Using configuration for function execution",
-		"cluster-id", clusterID,
-		"backup-scope", backupScope,
-		"store-type", config.StoreType)
 
-	// Extract claim and XR information from composite resource
-	var xrAPIVersion, xrKind, xrName, xrNamespace, claimNamespace, claimName string
+	// Extract claim and XR information from the observed composite (it has complete info)
+	keyParts := compositeKeyParts(req.GetObserved().GetComposite().GetResource())
+	f.log.Info("Extracted composition information",
+		"xr-api-version", keyParts.APIVersion,
+		"xr-kind", keyParts.Kind,
+		"xr-name", keyParts.Name,
+		"claim-namespace", keyParts.Namespace,
+		"claim-name", keyParts.ClaimName)
+
+	// Reject override annotations the input policy doesn't permit for this XR's namespace
+	if err := checkOverridePolicy(req, in.Policy); err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
 
-	// Use observed composite for metadata extraction (it has complete info)
-	if observedComposite := req.GetObserved().GetComposite().GetResource(); observedComposite != nil {
-		if fields := observedComposite.GetFields(); fields != nil {
-			if apiVersion := fields["apiVersion"]; apiVersion != nil {
-				xrAPIVersion = apiVersion.GetStringValue()
-			}
-			if kind := fields["kind"]; kind != nil {
-				xrKind = kind.GetStringValue()
-			}
-			if metadata := fields["metadata"]; metadata != nil {
-				if metadataStruct := metadata.GetStructValue(); metadataStruct != nil {
-					if name := metadataStruct.GetFields()["name"]; name != nil {
-						xrName = name.GetStringValue()
-					}
-					// Extract XR namespace (for namespaced XRs)
-					if ns := metadataStruct.GetFields()["namespace"]; ns != nil {
-						xrNamespace = ns.GetStringValue()
-					}
-					// Extract claim info from labels
-					if labels := metadataStruct.GetFields()["labels"]; labels != nil {
-						if labelsStruct := labels.GetStructValue(); labelsStruct != nil {
-							if claimNs := labelsStruct.GetFields()["crossplane.io/claim-namespace"]; claimNs != nil {
-								claimNamespace = claimNs.GetStringValue()
-							}
-							if claimN := labelsStruct.GetFields()["crossplane.io/claim-name"]; claimN != nil {
-								claimName = claimN.GetStringValue()
-							}
-						}
-					}
-				}
-			}
+	// Apply composition key override annotations (useful for migrations where key components change)
+	// Each override is checked on the desired composite first, then observed as fallback
+	compositionKey := f.applyKeyOverrides(&keyParts, func(annotation string) string {
+		return getCompositeAnnotation(req, annotation)
+	})
+
+	// Compute timestamp once for this operation
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	// Record what this run does for the backup summary and conditions
+	report := &runReport{CompositionKey: compositionKey, ClusterID: clusterID, StoreType: config.StoreType, Config: config.effective()}
+	switch {
+	case requireRestore:
+		report.Mode = "restore-only"
+	case backupOnly:
+		report.Mode = "backup-only"
+	}
+
+	purgeRetention := DefaultPurgeRetention
+	if in.Policy != nil && in.Policy.Purge != nil && in.Policy.Purge.Retention != nil {
+		purgeRetention = in.Policy.Purge.Retention.Duration
+	}
+
+	// The tombstone records the last purge of the composition, and holds its purged data until
+	// the retention expires
+	var tomb *tombstone
+	if storeWritesAllowed {
+		if tomb, err = loadTombstone(ctx, store, clusterID, compositionKey); err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "failed to load tombstone from store"))
+			return rsp, nil
 		}
 	}
+	now := time.Now()
+	purgeToken := ""
 
-	// Set defaults if claim info not found
-	// For namespaced XRs without claims, use XR namespace as fallback
-	if claimNamespace == "" {
-		if xrNamespace != "" {
-			claimNamespace = xrNamespace
-			f.log.Info("Using XR namespace as claim-namespace for namespaced XR without claim",
-				"xr-namespace", xrNamespace)
+	// Check if external store should be purged for this composition
+	if shouldPurgeExternalStore(req, f.log) {
+		if err := checkPurgePolicy(req, in.Policy); err != nil {
+			response.Fatal(rsp, err)
+			return rsp, nil
+		}
+		if isTrue(getCompositeAnnotation(req, UndeleteExternalStoreAnnotation)) {
+			response.Fatal(rsp, errors.Errorf("cannot set both %s and %s, remove the purge annotation before undeleting",
+				PurgeExternalStoreAnnotation, UndeleteExternalStoreAnnotation))
+			return rsp, nil
+		}
+
+		// The purge token must identify this XR, so a purge annotation copied to or left on
+		// another XR does nothing, and a purge that was already performed isn't repeated
+		token := getCompositeAnnotation(req, PurgeExternalStoreAnnotation)
+		uid, generation := getPurgeTokens(req.GetObserved().GetComposite().GetResource())
+		if token != uid && token != generation {
+			// A stale token, e.g. the generation of an earlier purge, does nothing
+			f.log.Info("Ignoring purge annotation that matches neither the XR's UID nor its generation",
+				"composition-key", compositionKey,
+				"purge-token", token)
+			response.Warning(rsp, errors.Errorf("ignoring %s: it must be set to the XR's UID %q or generation %q to purge, got %q",
+				PurgeExternalStoreAnnotation, uid, generation, token)).TargetCompositeAndClaim()
 		} else {
-			claimNamespace = "none"
+			if !storeWritesAllowed {
+				response.ConditionFalse(rsp, "FunctionSuccess", "PurgeRefused").
+					WithMessage("Purge refused: no cluster ID configured").
+					TargetCompositeAndClaim()
+				return rsp, nil
+			}
+
+			purgeToken = token
+
+			switch {
+			case tomb != nil && tomb.Token == token:
+				f.log.Info("Purge already performed for this purge token, continuing with normal processing",
+					"composition-key", compositionKey,
+					"purged-at", tomb.PurgedAt)
+				response.Normalf(rsp, "Composition %q was already purged at %s, remove the %s annotation",
+					compositionKey, tomb.PurgedAt.Format(time.RFC3339), PurgeExternalStoreAnnotation)
+			case purgeRetention <= 0:
+				f.log.Info("Purging external store for composition", "composition-key", compositionKey)
+				if err := hardPurge(ctx, store, clusterID, compositionKey, token, now); err != nil {
+					response.Fatal(rsp, errors.Wrapf(err, "failed to purge external store"))
+					return rsp, nil
+				}
+				f.log.Info("Successfully purged external store for composition", "composition-key", compositionKey)
+
+				response.Normalf(rsp, "Purged external store for composition %q", compositionKey)
+				response.ConditionTrue(rsp, "FunctionSuccess", "Success").
+					TargetCompositeAndClaim()
+				return rsp, nil
+			default:
+				f.log.Info("Purging external store for composition to tombstone", "composition-key", compositionKey)
+				// Purging again within the retention keeps the data purged before
+				live := tomb
+				if live != nil && live.expired(now, purgeRetention) {
+					live = nil
+				}
+				count, err := softPurge(ctx, store, clusterID, compositionKey, token, live, now)
+				if err != nil {
+					response.Fatal(rsp, errors.Wrapf(err, "failed to purge external store"))
+					return rsp, nil
+				}
+				f.log.Info("Successfully purged external store for composition", "composition-key", compositionKey, "count", count)
+
+				response.Normalf(rsp, "Purged %d resource entries from external store for composition %q, they can be undeleted with the %s annotation for %s",
+					count, compositionKey, UndeleteExternalStoreAnnotation, purgeRetention)
+				response.ConditionTrue(rsp, "FunctionSuccess", "Success").
+					TargetCompositeAndClaim()
+				return rsp, nil
+			}
 		}
 	}
-	if claimName == "" {
-		claimName = "none"
-	}
 
-	f.log.Info("Extracted composition information",
-		"xr-api-version", xrAPIVersion,
-		"xr-kind", xrKind,
-		"xr-name", xrName,
-		"xr-namespace", xrNamespace,
-		"claim-namespace", claimNamespace,
-		"claim-name", claimName)
-
-	// Check for kind override annotation (useful for migrations where XR kind changes)
-	// Check desired first, then observed as fallback
-	kindForKey := xrKind
-	var overrideKind string
-	if desiredComposite := req.GetDesired().GetComposite().GetResource(); desiredComposite != nil {
-		overrideKind = getAnnotationValue(desiredComposite, OverrideKindAnnotation)
-	}
-	if overrideKind == "" {
-		if observedComposite := req.GetObserved().GetComposite().GetResource(); observedComposite != nil {
-			overrideKind = getAnnotationValue(observedComposite, OverrideKindAnnotation)
+	// Check if purged data should be restored from the tombstone. Undeleting removes
+	// the tombstone, so it is performed only once even if the annotation stays.
+	if isTrue(getCompositeAnnotation(req, UndeleteExternalStoreAnnotation)) && storeWritesAllowed {
+		switch {
+		case tomb == nil:
+			f.log.Info("No tombstone found to undelete", "composition-key", compositionKey)
+		case tomb.expired(now, purgeRetention):
+			response.Warning(rsp, errors.Errorf("cannot undelete composition %q: purged data expired after %s and was removed",
+				compositionKey, purgeRetention)).TargetCompositeAndClaim()
+		default:
+			count, err := undelete(ctx, store, clusterID, compositionKey, tomb)
+			if err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "failed to undelete purged data"))
+				return rsp, nil
+			}
+			f.log.Info("Undeleted purged data", "composition-key", compositionKey, "count", count)
+			response.Normalf(rsp, "Undeleted %d resource entries purged at %s for composition %q, remove the %s annotation",
+				count, tomb.PurgedAt.Format(time.RFC3339), compositionKey, UndeleteExternalStoreAnnotation)
+			tomb = nil
 		}
 	}
-	if overrideKind != "" {
-		f.log.Info("Using override-kind for composition key lookup",
-			"original-kind", xrKind,
-			"override-kind", overrideKind)
-		kindForKey = overrideKind
-	}
 
-	// Check for namespace override annotation (useful for migrations from cluster-scoped to namespaced)
-	namespaceForKey := claimNamespace
-	var overrideNamespace string
-	if desiredComposite := req.GetDesired().GetComposite().GetResource(); desiredComposite != nil {
-		overrideNamespace = getAnnotationValue(desiredComposite, OverrideNamespaceAnnotation)
-	}
-	if overrideNamespace == "" {
-		if observedComposite := req.GetObserved().GetComposite().GetResource(); observedComposite != nil {
-			overrideNamespace = getAnnotationValue(observedComposite, OverrideNamespaceAnnotation)
+	// Remove an expired tombstone, unless the XR still carries the purge token it records, which
+	// would purge the composition again
+	if tomb != nil && tomb.expired(now, purgeRetention) && tomb.Token != purgeToken {
+		if err := store.Purge(ctx, clusterID, tombstoneKey(compositionKey)); err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "failed to remove expired tombstone"))
+			return rsp, nil
 		}
-	}
-	if overrideNamespace != "" {
-		f.log.Info("Using override-namespace for composition key lookup",
-			"original-namespace", claimNamespace,
-			"override-namespace", overrideNamespace)
-		namespaceForKey = overrideNamespace
+		f.log.Info("Removed expired tombstone", "composition-key", compositionKey, "purged-at", tomb.PurgedAt)
 	}
 
-	// Parse function input (for future extensibility)
-	in := &v1beta1.Input{}
-	if err := request.GetInput(req, in); err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get Function input from %T", req))
+	// Load existing resource data from pre-initialized store
+	loadedResources, err := store.Load(ctx, clusterID, compositionKey)
+	if err != nil {
+		response.Fatal(rsp, errors.Wrapf(err, "failed to load resource data from store"))
 		return rsp, nil
 	}
 
-	// Create composition key: {namespace}/{claimName}/{apiVersionOfXr}/{kindOfXr}/{metadata.name of XR}
-	// Note: Uses namespaceForKey and kindForKey which may be overridden by annotations
-	compositionKey := fmt.Sprintf("%s/%s/%s/%s/%s", namespaceForKey, claimName, xrAPIVersion, kindForKey, xrName)
-
-	// Compute timestamp once for this operation
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-
-	// Check if external store should be purged for this composition
-	if shouldPurgeExternalStore(req, f.log) {
-		f.log.Info("Purging external store for composition", "composition-key", compositionKey)
-		err := store.Purge(ctx, clusterID, compositionKey)
+	// Seed this cluster from the restore cluster when it has no data for the composition yet.
+	// Seeding only ever fills an empty key, so it is also performed in require-restore mode.
+	if len(loadedResources) == 0 && config.RestoreClusterID != "" && config.RestoreClusterID != clusterID && !backupOnly {
+		sourceResources, err := store.Load(ctx, config.RestoreClusterID, compositionKey)
 		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "failed to purge external store"))
+			response.Fatal(rsp, errors.Wrapf(err, "failed to load resource data from restore cluster %q", config.RestoreClusterID))
 			return rsp, nil
 		}
-		f.log.Info("Successfully purged external store for composition", "composition-key", compositionKey)
-
-		// Parse function input (for consistency)
-		in := &v1beta1.Input{}
-		if err := request.GetInput(req, in); err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "cannot get Function input from %T", req))
-			return rsp, nil
+		if len(sourceResources) > 0 && !storeWritesAllowed {
+			// Restore from the source cluster without seeding this one
+			loadedResources = sourceResources
+			f.log.Info("Restoring from restore cluster without seeding",
+				"restore-cluster-id", config.RestoreClusterID,
+				"composition-key", compositionKey)
+		} else if len(sourceResources) > 0 {
+			if err := store.Save(ctx, clusterID, compositionKey, sourceResources); err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "failed to copy resource data from restore cluster %q", config.RestoreClusterID))
+				return rsp, nil
+			}
+			loadedResources = sourceResources
+			f.log.Info("Seeded composition from restore cluster",
+				"restore-cluster-id", config.RestoreClusterID,
+				"cluster-id", clusterID,
+				"composition-key", compositionKey,
+				"count", len(sourceResources))
+			response.Normalf(rsp, "Copied %d resource entries (%s) for composition %q from cluster %q to cluster %q",
+				len(sourceResources), strings.Join(slices.Sorted(maps.Keys(sourceResources)), ", "),
+				compositionKey, config.RestoreClusterID, clusterID)
+		} else {
+			f.log.Info("No resource data found in restore cluster",
+				"restore-cluster-id", config.RestoreClusterID,
+				"composition-key", compositionKey)
 		}
+	}
 
-		response.Normalf(rsp, "Purged external store for composition %q", compositionKey)
-		response.ConditionTrue(rsp, "FunctionSuccess", "Success").
-			TargetCompositeAndClaim()
+	// Resource data used for restoring. This is the data stored under the current
+	// composition key unless it is empty and a fallback key has data.
+	restoreResources := loadedResources
+	if len(loadedResources) == 0 && in.Restore != nil && !backupOnly {
+		for _, candidate := range resolveFallbackKeys(in.Restore.FallbackKeys, clusterID, keyParts) {
+			fallbackResources, err := store.Load(ctx, candidate.ClusterID, candidate.CompositionKey)
+			if err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "failed to load resource data from store for fallback composition key %q", candidate.CompositionKey))
+				return rsp, nil
+			}
+			if len(fallbackResources) == 0 {
+				f.log.Info("No resource data found under fallback composition key",
+					"cluster-id", candidate.ClusterID,
+					"composition-key", candidate.CompositionKey)
+				continue
+			}
 
-		return rsp, nil
+			f.log.Info("Using resource data from fallback composition key",
+				"cluster-id", candidate.ClusterID,
+				"composition-key", candidate.CompositionKey,
+				"loaded-count", len(fallbackResources))
+			response.Normalf(rsp, "Restoring resource data from fallback composition key %q (cluster %q)",
+				candidate.CompositionKey, candidate.ClusterID)
+			restoreResources = fallbackResources
+
+			// Copying forward only fills the empty current key, so it never overwrites
+			// existing backup data and is allowed in require-restore mode too
+			if in.Restore.CopyForward && storeWritesAllowed {
+				if err := store.Save(ctx, clusterID, compositionKey, fallbackResources); err != nil {
+					response.Fatal(rsp, errors.Wrapf(err, "failed to copy resource data from fallback composition key %q", candidate.CompositionKey))
+					return rsp, nil
+				}
+				loadedResources = make(map[string]ResourceData, len(fallbackResources))
+				for k, v := range fallbackResources {
+					loadedResources[k] = v
+				}
+				f.log.Info("Copied resource data from fallback composition key",
+					"from-cluster-id", candidate.ClusterID,
+					"from-composition-key", candidate.CompositionKey,
+					"composition-key", compositionKey,
+					"count", len(fallbackResources))
+				response.Normalf(rsp, "Copied %d resource entries from fallback composition key %q to %q",
+					len(fallbackResources), candidate.CompositionKey, compositionKey)
+			}
+			break
+		}
 	}
 
-	// Load existing resource data from pre-initialized store
-	loadedResources, err := store.Load(ctx, clusterID, compositionKey)
+	// Apply resource rename mappings so renamed pipeline resources find the data
+	// stored under their previous name
+	renames, err := getResourceRenames(getCompositeAnnotation(req, ResourceRenamesAnnotation), in)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "failed to load resource data from store"))
+		response.Fatal(rsp, err)
 		return rsp, nil
 	}
+	if backupOnly {
+		renames = nil
+	}
+	desiredNames := make([]string, 0, len(req.GetDesired().GetResources()))
+	for name := range req.GetDesired().GetResources() {
+		desiredNames = append(desiredNames, name)
+	}
+	restoreResources = maps.Clone(restoreResources)
+	usedRenames := applyResourceRenames(restoreResources, desiredNames, renames)
+	for _, newName := range slices.Sorted(maps.Keys(usedRenames)) {
+		f.log.Info("Using rename mapping for resource lookup",
+			"resource", newName,
+			"stored-resource", usedRenames[newName])
+		response.Normalf(rsp, "Restoring resource %q from stored entry %q using rename mapping", newName, usedRenames[newName])
+	}
 
-	// Safety check: if require-restore is set and no data found, fail to prevent accidental creation
-	requireRestore := shouldRequireRestore(req)
-	if requireRestore && len(loadedResources) == 0 {
-		response.Fatal(rsp, errors.Errorf(
-			"require-restore is enabled but no resource data found in store for composition key %q. "+
-				"Check that override-kind and override-namespace annotations are correct, or remove require-restore annotation.",
-			compositionKey))
+	// External names committed to Git take precedence over the store, which then only acts as a safety net
+	gitNames, err := getExternalNamesAnnotation(getCompositeAnnotation(req, ExternalNamesAnnotation))
+	if err != nil {
+		response.Fatal(rsp, err)
 		return rsp, nil
 	}
+	// Resources whose only data is in the annotation, they don't count as stored in restore-only mode
+	annotationOnly := make(map[string]bool)
+	if len(gitNames) > 0 && !backupOnly {
+		if restoreResources == nil {
+			restoreResources = make(map[string]ResourceData, len(gitNames))
+		}
+		for name, externalName := range gitNames {
+			data, stored := restoreResources[name]
+			if !stored {
+				annotationOnly[name] = true
+			}
+			data.ExternalName = externalName
+			restoreResources[name] = data
+		}
+		f.log.Info("Using external names from XR annotation", "annotation", ExternalNamesAnnotation, "count", len(gitNames))
+	}
+
+	// Rewriting never happens in require-restore mode because it removes stored entries
+	if len(usedRenames) > 0 && in.Restore != nil && in.Restore.RewriteRenamedResources && !requireRestore && storeWritesAllowed {
+		rewritten := rewriteRenamedResources(loadedResources, usedRenames, desiredNames)
+		if rewritten > 0 {
+			if err := store.Save(ctx, clusterID, compositionKey, loadedResources); err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "failed to rewrite renamed resources in store"))
+				return rsp, nil
+			}
+			f.log.Info("Rewrote renamed resources in store", "composition-key", compositionKey, "count", rewritten)
+			response.Normalf(rsp, "Rewrote %d renamed resource entries in store for composition %q", rewritten, compositionKey)
+		}
+	}
+
+	// Purge the stored data of selected composed resources. Purged resources are neither
+	// restored nor backed up while the annotation is set, so that a resource recreated
+	// in the cloud doesn't get its old external name back.
+	purgedResources := make(map[string]bool)
+	if purgeSelector := parseResourceSelector(getCompositeAnnotation(req, PurgeResourcesAnnotation)); len(purgeSelector) > 0 {
+		if err := checkPurgePolicy(req, in.Policy); err != nil {
+			response.Fatal(rsp, err)
+			return rsp, nil
+		}
+
+		candidates := slices.Collect(maps.Keys(loadedResources))
+		candidates = slices.AppendSeq(candidates, maps.Keys(restoreResources))
+		candidates = slices.AppendSeq(candidates, maps.Keys(req.GetDesired().GetResources()))
+		candidates = slices.AppendSeq(candidates, maps.Keys(req.GetObserved().GetResources()))
+		for _, name := range candidates {
+			if purgeSelector.matches(name, getResourceKind(req, name)) {
+				purgedResources[name] = true
+			}
+		}
+
+		var purged []string
+		for _, name := range slices.Sorted(maps.Keys(purgedResources)) {
+			report.record(name, ResourceStatePurged, ResourceData{})
+			delete(restoreResources, name)
+			f.removeTrackingAnnotationsFromObserved(req, name)
+
+			if _, stored := loadedResources[name]; !stored || !storeWritesAllowed {
+				continue
+			}
+			if err := store.DeleteResource(ctx, clusterID, compositionKey, name); err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "failed to purge resource %q from store", name))
+				return rsp, nil
+			}
+			delete(loadedResources, name)
+			purged = append(purged, name)
+			f.log.Info("Purged resource from store", "resource", name, "composition-key", compositionKey)
+		}
+		if len(purged) > 0 {
+			response.Normalf(rsp, "Purged stored data for %d resources (%s) of composition %q, remove the %s annotation to back them up again",
+				len(purged), strings.Join(purged, ", "), compositionKey, PurgeResourcesAnnotation)
+		}
+	}
+	skipRestoreSelector := parseResourceSelector(getCompositeAnnotation(req, SkipRestoreResourcesAnnotation))
 
-	// Convert to nested structure for processing
+	// Convert to nested structure for processing. This always reflects the data
+	// stored under the current composition key, which is where backups are saved.
 	resourceDataStore := map[string]map[string]ResourceData{
 		compositionKey: loadedResources,
 	}
 	f.log.Info("Loaded resource data from store",
 		"composition-key", compositionKey,
 		"loaded-count", len(loadedResources),
+		"restore-count", len(restoreResources),
 		"require-restore", requireRestore)
 
 	// Track only NEW resource data that should be stored (not restored ones)
@@ -871,6 +1357,13 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 	// Pre-calculate shouldProcess for all resources to avoid redundant checks
 	resourceShouldProcess := make(map[string]bool)
 
+	// Backup policies forced by backup-policy annotations or input selectors. Selectors
+	// match the desired resource, observed-only resources are matched when they are processed.
+	backupPolicies := make(map[string]string)
+	for name, resource := range req.GetDesired().GetResources() {
+		backupPolicies[name] = f.getBackupPolicy(req, in.Backup, name, resource.GetResource().GetFields())
+	}
+
 	// First pass: Check all desired resources for deletion from external store
 	// This needs to happen before restoration to prevent restoring resources that should be deleted
 	for name, resource := range req.GetDesired().GetResources() {
@@ -889,8 +1382,11 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			// Check for stored-external-name annotation (desired resource first, then observed as fallback)
 			hasStoredAnnotation := getAnnotationValueFromResource(req, resourceName, StoredExternalNameAnnotation) != ""
 
+			// A deletion that failed earlier is retried, even if the tracking annotations are gone
+			deletePending := getAnnotationValueFromResource(req, resourceName, ExternalNameDeletePendingAnnotation)
+
 			// Only check deletion criteria if we found the stored annotation somewhere
-			if hasStoredAnnotation {
+			if hasStoredAnnotation || deletePending != "" {
 				// Check deletion policy and management policies, preferring desired over observed
 				observedFields := make(map[string]*structpb.Value)
 				if observedResource, exists := req.GetObserved().GetResources()[resourceName]; exists {
@@ -901,6 +1397,25 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 				shouldDelete = f.shouldDeleteFromExternalStoreWithFallback(fields, observedFields, resourceName)
 			}
 
+			if shouldDelete && backupPolicies[resourceName] == BackupPolicyAlways {
+				f.log.Info("Skipping deletion from store - resource is always backed up", "resource", resourceName)
+				shouldDelete = false
+			}
+
+			if shouldDelete && !storeWritesAllowed {
+				f.log.Info("Skipping deletion from store - writes are not allowed", "resource", resourceName)
+				shouldDelete = false
+			}
+
+			// Drop a pending deletion when the resource no longer meets the deletion criteria
+			if !shouldDelete && deletePending != "" && storeWritesAllowed {
+				f.log.Info("Dropping pending deletion - resource no longer meets deletion criteria", "resource", resourceName)
+				removeAnnotations(resourceStruct, ExternalNameDeletePendingAnnotation)
+				if observedResource, exists := req.GetObserved().GetResources()[resourceName]; exists {
+					removeAnnotations(observedResource.GetResource(), ExternalNameDeletePendingAnnotation)
+				}
+			}
+
 			if shouldDelete {
 				resourceKey := resourceName
 
@@ -914,21 +1429,33 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 					f.log.Info("Failed to delete resource from store",
 						"resource", resourceName,
 						"error", err.Error())
+					response.Warning(rsp, errors.Wrapf(err, "failed to delete stored data for resource %q, retrying on the next reconcile", resourceName)).
+						TargetCompositeAndClaim()
+					report.record(resourceName, ResourceStateDeletePending, ResourceData{})
+
+					// Don't restore data for a resource that is being deleted
+					delete(restoreResources, resourceKey)
 				} else {
 					f.log.Info("Deleted resource from store",
 						"resource", resourceName,
 						"resource-key", resourceKey)
+					report.record(resourceName, ResourceStateDeleted, ResourceData{})
+					response.Normalf(rsp, "Deleted stored data for resource %q because it is no longer orphaned on deletion", resourceName).
+						TargetCompositeAndClaim()
 
 					// Remove from local cache so it doesn't get re-added during save
 					if compositionData, exists := resourceDataStore[compositionKey]; exists {
 						delete(compositionData, resourceKey)
 					}
+					// Don't restore data for a resource that was just deleted
+					delete(restoreResources, resourceKey)
 
 					// Remove tracking annotations from observed resource to prevent them from being preserved
 					f.removeTrackingAnnotationsFromObserved(req, resourceName)
 				}
 
-				// Remove tracking annotations and add deletion timestamp to desired resource
+				// Remove tracking annotations and add deletion timestamp to desired resource,
+				// or queue the deletion for a retry if it failed
 				// Ensure metadata exists
 				if fields["metadata"] == nil {
 					fields["metadata"] = &structpb.Value{
@@ -963,9 +1490,25 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 								fields = annotationsStruct.GetFields()
 							}
 
+							if err != nil {
+								// Keep the tracking annotations, since the entry is still stored,
+								// and record when the deletion first failed
+								since := deletePending
+								if since == "" {
+									since = timestamp
+								}
+								fields[ExternalNameDeletePendingAnnotation] = structpb.NewStringValue(since)
+
+								f.log.Info("Queued deletion for retry",
+									"resource", resourceName,
+									"pending-since", since)
+								continue
+							}
+
 							// Remove tracking annotations
 							delete(fields, StoredExternalNameAnnotation)
 							delete(fields, ExternalNameStoredAnnotation)
+							delete(fields, ExternalNameDeletePendingAnnotation)
 
 							// Add deletion timestamp
 							fields[ExternalNameDeletedAnnotation] = &structpb.Value{
@@ -985,7 +1528,7 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 	}
 
 	// After all deletions, check if the composition is empty and clean it up
-	if compositionData, exists := resourceDataStore[compositionKey]; exists && len(compositionData) == 0 {
+	if compositionData, exists := resourceDataStore[compositionKey]; exists && len(compositionData) == 0 && storeWritesAllowed {
 		f.log.Info("Composition has no resource data left, purging entire composition from store",
 			"composition-key", compositionKey)
 
@@ -994,6 +1537,10 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			f.log.Info("Failed to purge empty composition from store",
 				"composition-key", compositionKey,
 				"error", err.Error())
+			// The composition is still empty on the next reconcile, so the purge is retried then
+			response.Warning(rsp, errors.Wrapf(err, "failed to purge empty composition %q from store, retrying on the next reconcile", compositionKey)).
+				TargetCompositeAndClaim()
+			report.purgeFailed = true
 		} else {
 			f.log.Info("Successfully purged empty composition from store",
 				"composition-key", compositionKey)
@@ -1004,6 +1551,8 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 	}
 
 	// Second pass: Iterate through all desired resources from previous pipeline steps for restoration
+	skippedRestores := 0 // resources with stored data that weren't restored because of backup-only mode
+	var missingResources, allowedMissingResources []string
 	for name, resource := range req.GetDesired().GetResources() {
 		resourceStruct := resource.GetResource()
 		if resourceStruct != nil && resourceStruct.GetFields() != nil {
@@ -1028,7 +1577,7 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 
 			// Check if this resource should be processed for external store operations
 			// When requireRestore is true, we always attempt restore but never backup
-			shouldProcess := f.shouldProcessResource(fields, resourceName, backupScope)
+			shouldProcess := f.shouldProcessResourceWithPolicy(fields, resourceName, backupScope, backupPolicies[resourceName])
 			if !requireRestore {
 				resourceShouldProcess[resourceName] = shouldProcess // Only cache for backup when not in restore mode
 			}
@@ -1036,6 +1585,24 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			// When requireRestore is true, always continue to attempt restore
 			if !shouldProcess && !requireRestore {
 				f.log.Info("Skipping external store operations for desired resource due to backup scope", "resource", resourceName, "scope", backupScope)
+				report.record(resourceName, ResourceStateSkipped, ResourceData{})
+				continue
+			}
+
+			if backupOnly {
+				if _, stored := restoreResources[resourceName]; stored {
+					skippedRestores++
+				}
+				f.log.Info("Skipping restore for resource - backup-only mode is enabled", "resource", resourceName)
+				report.record(resourceName, ResourceStateSkipped, ResourceData{})
+				continue
+			}
+			if purgedResources[resourceName] {
+				continue
+			}
+			if skipRestoreSelector.matches(resourceName, kind) || backupPolicies[resourceName] == BackupPolicyNever {
+				f.log.Info("Skipping restore for resource selected by annotation", "resource", resourceName)
+				report.record(resourceName, ResourceStateSkipped, ResourceData{})
 				continue
 			}
 
@@ -1051,139 +1618,176 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			resourceKey := resourceName
 
 			// Check if we have data for this resource in our store
-			if compositionData, compositionExists := resourceDataStore[compositionKey]; compositionExists {
-				if storedData, resourceExists := compositionData[resourceKey]; resourceExists {
-					// Ensure metadata exists before any restoration
-					if fields["metadata"] == nil {
-						fields["metadata"] = &structpb.Value{
-							Kind: &structpb.Value_StructValue{
-								StructValue: &structpb.Struct{
-									Fields: make(map[string]*structpb.Value),
-								},
+			if storedData, resourceExists := restoreResources[resourceKey]; resourceExists {
+				// An external name from the annotation doesn't prove that the store holds the
+				// composition's data, a wiped store must still fail restore-only mode
+				if requireRestore && annotationOnly[resourceKey] && restoreRequirement.required(resourceName, fields) {
+					missingResources = append(missingResources, resourceName)
+				}
+				restored := (!hasExistingResourceName && storedData.ResourceName != "") ||
+					(!hasExistingExternalName && storedData.ExternalName != "")
+				if restored {
+					report.record(resourceName, ResourceStateRestored, storedData)
+				} else {
+					report.record(resourceName, ResourceStateUnchanged, storedData)
+				}
+
+				// The current external name wins over stored data and is backed up in place of it
+				if hasExistingExternalName && storedData.ExternalName != "" && existingExternalName != storedData.ExternalName {
+					f.log.Info("Existing external name differs from stored external name",
+						"resource", resourceName,
+						"external-name", existingExternalName,
+						"stored-external-name", storedData.ExternalName)
+					response.Warning(rsp, errors.Errorf("resource %q has external name %q, but %q is stored: keeping the current external name",
+						resourceName, existingExternalName, storedData.ExternalName)).
+						TargetCompositeAndClaim()
+				}
+
+				// Ensure metadata exists before any restoration
+				if fields["metadata"] == nil {
+					fields["metadata"] = &structpb.Value{
+						Kind: &structpb.Value_StructValue{
+							StructValue: &structpb.Struct{
+								Fields: make(map[string]*structpb.Value),
 							},
-						}
+						},
 					}
+				}
 
-					if metadata := fields["metadata"]; metadata != nil {
-						if metadataStruct := metadata.GetStructValue(); metadataStruct != nil {
-							metadataFields := metadataStruct.GetFields()
+				if metadata := fields["metadata"]; metadata != nil {
+					if metadataStruct := metadata.GetStructValue(); metadataStruct != nil {
+						metadataFields := metadataStruct.GetFields()
 
-							// Restore resource name (metadata.name) if not already set
-							if !hasExistingResourceName && storedData.ResourceName != "" {
-								f.log.Info("Restoring resource name from store",
-									"resource", resourceName,
-									"resource-name", storedData.ResourceName,
-									"timestamp", timestamp,
-								)
+						// Restore resource name (metadata.name) if not already set
+						if !hasExistingResourceName && storedData.ResourceName != "" {
+							f.log.Info("Restoring resource name from store",
+								"resource", resourceName,
+								"resource-name", storedData.ResourceName,
+								"timestamp", timestamp,
+							)
 
-								// Set metadata.name
-								metadataFields["name"] = &structpb.Value{
-									Kind: &structpb.Value_StringValue{
-										StringValue: storedData.ResourceName,
+							// Set metadata.name
+							metadataFields["name"] = &structpb.Value{
+								Kind: &structpb.Value_StringValue{
+									StringValue: storedData.ResourceName,
+								},
+							}
+							response.Normalf(rsp, "Restored name %q for resource %q from backup", storedData.ResourceName, resourceName).
+								TargetCompositeAndClaim()
+
+							// Ensure annotations exist for tracking
+							if metadataFields["annotations"] == nil {
+								metadataFields["annotations"] = &structpb.Value{
+									Kind: &structpb.Value_StructValue{
+										StructValue: &structpb.Struct{
+											Fields: make(map[string]*structpb.Value),
+										},
 									},
 								}
+							}
 
-								// Ensure annotations exist for tracking
-								if metadataFields["annotations"] == nil {
-									metadataFields["annotations"] = &structpb.Value{
-										Kind: &structpb.Value_StructValue{
-											StructValue: &structpb.Struct{
-												Fields: make(map[string]*structpb.Value),
-											},
-										},
-									}
+							if annotationsStruct := metadataFields["annotations"].GetStructValue(); annotationsStruct != nil {
+								if annotationsStruct.Fields == nil {
+									annotationsStruct.Fields = make(map[string]*structpb.Value)
 								}
 
-								if annotationsStruct := metadataFields["annotations"].GetStructValue(); annotationsStruct != nil {
-									if annotationsStruct.Fields == nil {
-										annotationsStruct.Fields = make(map[string]*structpb.Value)
-									}
-
-									// Add tracking annotations for resource name
-									annotationsStruct.Fields[StoredResourceNameAnnotation] = &structpb.Value{
-										Kind: &structpb.Value_StringValue{
-											StringValue: storedData.ResourceName,
-										},
-									}
-									annotationsStruct.Fields[ResourceNameRestoredAnnotation] = &structpb.Value{
-										Kind: &structpb.Value_StringValue{
-											StringValue: timestamp,
-										},
-									}
+								// Add tracking annotations for resource name
+								annotationsStruct.Fields[StoredResourceNameAnnotation] = &structpb.Value{
+									Kind: &structpb.Value_StringValue{
+										StringValue: storedData.ResourceName,
+									},
+								}
+								annotationsStruct.Fields[ResourceNameRestoredAnnotation] = &structpb.Value{
+									Kind: &structpb.Value_StringValue{
+										StringValue: timestamp,
+									},
 								}
 							}
+						}
 
-							// Restore external name if not already set
-							if !hasExistingExternalName && storedData.ExternalName != "" {
-								f.log.Info("Restoring external-name from store",
-									"resource", resourceName,
-									"external-name", storedData.ExternalName,
-									"timestamp", timestamp,
-								)
-
-								// Ensure annotations exist
-								if metadataFields["annotations"] == nil {
-									metadataFields["annotations"] = &structpb.Value{
-										Kind: &structpb.Value_StructValue{
-											StructValue: &structpb.Struct{
-												Fields: make(map[string]*structpb.Value),
-											},
+						// Restore external name if not already set
+						if !hasExistingExternalName && storedData.ExternalName != "" {
+							f.log.Info("Restoring external-name from store",
+								"resource", resourceName,
+								"external-name", storedData.ExternalName,
+								"timestamp", timestamp,
+							)
+							response.Normalf(rsp, "Restored external name %q for resource %q from backup", storedData.ExternalName, resourceName).
+								TargetCompositeAndClaim()
+
+							// Ensure annotations exist
+							if metadataFields["annotations"] == nil {
+								metadataFields["annotations"] = &structpb.Value{
+									Kind: &structpb.Value_StructValue{
+										StructValue: &structpb.Struct{
+											Fields: make(map[string]*structpb.Value),
 										},
-									}
+									},
 								}
+							}
 
-								if annotationsStruct := metadataFields["annotations"].GetStructValue(); annotationsStruct != nil {
-									if annotationsStruct.Fields == nil {
-										annotationsStruct.Fields = make(map[string]*structpb.Value)
-									}
+							if annotationsStruct := metadataFields["annotations"].GetStructValue(); annotationsStruct != nil {
+								if annotationsStruct.Fields == nil {
+									annotationsStruct.Fields = make(map[string]*structpb.Value)
+								}
 
-									// Set the external-name annotation
-									annotationsStruct.Fields["crossplane.io/external-name"] = &structpb.Value{
-										Kind: &structpb.Value_StringValue{
-											StringValue: storedData.ExternalName,
-										},
-									}
+								// Set the external-name annotation
+								annotationsStruct.Fields["crossplane.io/external-name"] = &structpb.Value{
+									Kind: &structpb.Value_StringValue{
+										StringValue: storedData.ExternalName,
+									},
+								}
 
-									// Add tracking annotation
-									annotationsStruct.Fields[StoredExternalNameAnnotation] = &structpb.Value{
-										Kind: &structpb.Value_StringValue{
-											StringValue: storedData.ExternalName,
-										},
-									}
+								// Add tracking annotation
+								annotationsStruct.Fields[StoredExternalNameAnnotation] = &structpb.Value{
+									Kind: &structpb.Value_StringValue{
+										StringValue: storedData.ExternalName,
+									},
+								}
 
-									// Add restoration timestamp annotation
-									annotationsStruct.Fields[ExternalNameRestoredAnnotation] = &structpb.Value{
-										Kind: &structpb.Value_StringValue{
-											StringValue: timestamp,
-										},
-									}
+								// Add restoration timestamp annotation
+								annotationsStruct.Fields[ExternalNameRestoredAnnotation] = &structpb.Value{
+									Kind: &structpb.Value_StringValue{
+										StringValue: timestamp,
+									},
 								}
 							}
 						}
 					}
-				} else {
-					f.log.Info("No data found in store for resource", "resource", resourceName, "composition-key", compositionKey, "resource-key", resourceKey)
-					if requireRestore {
-						response.Fatal(rsp, errors.Errorf(
-							"require-restore is enabled but no data found in store for resource %q (composition key: %q). "+
-								"All resources must have data in the store when require-restore is set.",
-							resourceName, compositionKey))
-						return rsp, nil
-					}
 				}
 			} else {
-				f.log.Info("No composition found in store", "composition-key", compositionKey)
-				if requireRestore {
-					response.Fatal(rsp, errors.Errorf(
-						"require-restore is enabled but no composition data found in store for key %q. "+
-							"Check that override-kind and override-namespace annotations are correct.",
-						compositionKey))
-					return rsp, nil
+				f.log.Info("No data found in store for resource", "resource", resourceName, "composition-key", compositionKey, "resource-key", resourceKey)
+				if !hasExistingExternalName {
+					report.record(resourceName, ResourceStateMissing, ResourceData{})
+				}
+				// Collect missing resources so that all of them are reported at once
+				if requireRestore && restoreRequirement.required(resourceName, fields) {
+					missingResources = append(missingResources, resourceName)
+				} else if requireRestore {
+					allowedMissingResources = append(allowedMissingResources, resourceName)
 				}
 			}
 		}
 	}
 
+	// Safety check: fail to prevent accidental creation of resources that require a restore
+	if len(missingResources) > 0 {
+		slices.Sort(missingResources)
+		hint := ""
+		if !slices.ContainsFunc(slices.Collect(maps.Keys(restoreResources)), func(name string) bool { return !annotationOnly[name] }) {
+			hint = " No resource data was found for the composition key or any fallback key, check that the override annotations are correct."
+		}
+		response.Fatal(rsp, errors.Errorf(
+			"require-restore is enabled but no data found in store for %d resources: %s (composition key: %q). "+
+				"These resources must have data in the store when require-restore is set, or be allowed to be missing with %s.%s",
+			len(missingResources), strings.Join(missingResources, ", "), compositionKey, RestoreOnlyAllowMissingAnnotation, hint))
+		return rsp, nil
+	}
+	if len(allowedMissingResources) > 0 {
+		slices.Sort(allowedMissingResources)
+		response.Normalf(rsp, "Resources without stored data allowed in restore-only mode: %s", strings.Join(allowedMissingResources, ", "))
+	}
+
 	// Iterate through all observed resources from previous pipeline steps
 	f.log.Info("Checking observed resources", "count", len(req.GetObserved().GetResources()))
 	for name, resource := range req.GetObserved().GetResources() {
@@ -1208,10 +1812,20 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 				"kind", kind,
 			)
 
+			if purgedResources[resourceName] {
+				f.log.Info("Skipping backup for purged resource", "resource", resourceName)
+				continue
+			}
+
 			// Check if this resource should be processed for external store operations
+			backupPolicy, exists := backupPolicies[resourceName]
+			if !exists {
+				backupPolicy = f.getBackupPolicy(req, in.Backup, resourceName, fields)
+				backupPolicies[resourceName] = backupPolicy
+			}
 			shouldProcessForStore, exists := resourceShouldProcess[resourceName]
 			if !exists {
-				shouldProcessForStore = f.shouldProcessResource(fields, resourceName, backupScope)
+				shouldProcessForStore = f.shouldProcessResourceWithPolicy(fields, resourceName, backupScope, backupPolicy)
 				resourceShouldProcess[resourceName] = shouldProcessForStore
 			}
 
@@ -1228,8 +1842,9 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			shouldStoreExternalName := shouldProcessForStore && externalNameValue != "" && storedExternalName != externalNameValue
 
 			// Resource name (metadata.name) backup is independent of backup scope
-			// because XRs and other non-managed resources don't have deletion policies
-			shouldStoreResourceName := resourceNameValue != "" && storedResourceName != resourceNameValue
+			// because XRs and other non-managed resources don't have deletion policies,
+			// but resources that are never backed up are skipped entirely
+			shouldStoreResourceName := backupPolicy != BackupPolicyNever && resourceNameValue != "" && storedResourceName != resourceNameValue
 
 			if shouldStoreExternalName || shouldStoreResourceName {
 				// Create resource key for this observed resource
@@ -1272,6 +1887,8 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 	// Skip backup entirely when requireRestore is true to prevent overwriting stored data
 	if requireRestore {
 		f.log.Info("Skipping backup operations - require-restore mode is enabled")
+	} else if !storeWritesAllowed {
+		f.log.Info("Skipping backup operations - writes are not allowed under the default cluster ID")
 	} else if len(newResourceData) > 0 {
 		// Merge new resource data with existing ones
 		allResourceData := make(map[string]ResourceData)
@@ -1301,6 +1918,20 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			return rsp, nil
 		}
 		f.log.Info("Saved updated resource data to store", "composition-key", compositionKey, "new-count", len(newResourceData), "total-count", len(allResourceData))
+		for _, k := range slices.Sorted(maps.Keys(newResourceData)) {
+			report.record(k, ResourceStateStored, allResourceData[k])
+
+			var stored []string
+			if newResourceData[k].ExternalName != "" {
+				stored = append(stored, "external name "+strconv.Quote(newResourceData[k].ExternalName))
+			}
+			if newResourceData[k].ResourceName != "" {
+				stored = append(stored, "name "+strconv.Quote(newResourceData[k].ResourceName))
+			}
+			response.Normalf(rsp, "Backed up %s for resource %q", strings.Join(stored, " and "), k).
+				TargetCompositeAndClaim()
+		}
+		report.LastBackupTime = timestamp
 
 		// Add tracking annotations to desired resources for what was successfully stored
 		for name, resource := range req.GetDesired().GetResources() {
@@ -1410,6 +2041,35 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		len(req.GetDesired().GetResources()),
 		len(req.GetObserved().GetResources()))
 
+	// Pass the stored resource data on to later functions in the pipeline
+	if err := setRestoredDataContext(rsp, restoredDataContext{
+		CompositionKey: compositionKey,
+		ClusterID:      clusterID,
+		Resources:      restoreResources,
+		Restored:       report.withState(ResourceStateRestored),
+	}); err != nil {
+		f.log.Info("Failed to write restored data to the pipeline context", "error", err.Error())
+	}
+
+	f.reportStatus(req, rsp, report, statusField, requireRestore, backupOnly, storeWritesAllowed)
+
+	// Report backup-only mode, and clear the condition once the mode is turned off
+	if backupOnly {
+		backedUp := 0
+		if storeWritesAllowed {
+			backedUp = len(newResourceData)
+		}
+		response.Normalf(rsp, "Backup-only mode: backed up %d resources, skipped restoring %d resources with stored data",
+			backedUp, skippedRestores)
+		response.ConditionTrue(rsp, BackupOnlyConditionType, "BackupOnlyMode").
+			WithMessage("Resource data is backed up but not restored").
+			TargetCompositeAndClaim()
+	} else if hasCompositeCondition(req, BackupOnlyConditionType) {
+		response.ConditionFalse(rsp, BackupOnlyConditionType, "RestoreEnabled").
+			WithMessage("Stored resource data is restored").
+			TargetCompositeAndClaim()
+	}
+
 	// You can set a custom status condition on the claim. This allows you to
 	// communicate with the user. See the link below for status condition
 	// guidance.
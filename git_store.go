@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	// GitCredentialsName is the name of the function credentials of the git store
+	GitCredentialsName = "git-creds"
+
+	// gitPushAttempts bounds the pull-rebase-push attempts of a change
+	gitPushAttempts = 5
+	// gitSyncTTL is how long a clone is used without fetching after it was synced with the remote
+	gitSyncTTL = 5 * time.Second
+	// gitCommitter is the author and committer of the git store's commits
+	gitCommitter = "function-external-name-backup-restore"
+	// gitCommitterEmail is the email of the git store's commits
+	gitCommitterEmail = "external-name-backup@fn.crossplane.io"
+)
+
+// gitRepos are the local clones of the git stores, shared by the function's runs
+var gitRepos = struct {
+	mu    sync.Mutex
+	repos map[string]*gitRepo
+}{repos: make(map[string]*gitRepo)}
+
+// gitRepo is a local clone of a branch of a git store repository
+type gitRepo struct {
+	// mu serializes the git commands on the clone
+	mu     sync.Mutex
+	url    string
+	branch string
+	dir    string
+	// syncTTL is how long the clone is used without fetching after synced
+	syncTTL time.Duration
+	// synced is when the clone last matched the remote branch, zero if it may have diverged
+	synced time.Time
+}
+
+// GitStore implements ResourceStore by committing a file per composition to a git repository
+type GitStore struct {
+	log  logging.Logger
+	repo *gitRepo
+	// env authenticates the git commands
+	env []string
+}
+
+// backupFile is the content of a composition's file in the git store
+type backupFile struct {
+	CompositionKey string                  `json:"compositionKey"`
+	Resources      map[string]ResourceData `json:"resources"`
+}
+
+// getGitCredentials retrieves the git store credentials from the request (returns nil if not found).
+// They hold either a token, with an optional username, or an SSH private key and known hosts.
+func getGitCredentials(req *fnv1.RunFunctionRequest) map[string]string {
+	return getCredentialData(req, GitCredentialsName)
+}
+
+// NewGitStore creates a git store for a branch of a repository. Without credentials, git
+// authenticates as configured in its environment, e.g. by an SSH agent or credential helper.
+func NewGitStore(ctx context.Context, log logging.Logger, repository, branch string, creds map[string]string) (*GitStore, error) {
+	if repository == "" {
+		return nil, fmt.Errorf("no git repository configured, set %s", ConfigGitRepository)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("the git store requires the git binary, use the image-git image: %w", err)
+	}
+
+	gitRepos.mu.Lock()
+	id := repository + "#" + branch
+	repo, ok := gitRepos.repos[id]
+	if !ok {
+		sum := sha256.Sum256([]byte(id))
+		repo = &gitRepo{url: repository, branch: branch, dir: filepath.Join(os.TempDir(), "external-name-backup-git", hex.EncodeToString(sum[:8])), syncTTL: gitSyncTTL}
+		gitRepos.repos[id] = repo
+	}
+	gitRepos.mu.Unlock()
+
+	return newGitStore(ctx, log, repo, creds)
+}
+
+// newGitStore creates a git store on a local clone, initializing the clone if needed
+func newGitStore(ctx context.Context, log logging.Logger, repo *gitRepo, creds map[string]string) (*GitStore, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	env, err := repo.authEnv(creds)
+	if err != nil {
+		return nil, err
+	}
+	g := &GitStore{log: log, repo: repo, env: env}
+
+	if _, err := os.Stat(filepath.Join(repo.dir, ".git")); err == nil {
+		return g, nil
+	}
+	if err := os.MkdirAll(repo.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create git store directory: %w", err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", repo.url},
+		{"symbolic-ref", "HEAD", "refs/heads/" + repo.branch},
+	} {
+		if _, err := g.git(ctx, args...); err != nil {
+			return nil, err
+		}
+	}
+	log.Info("Initialized git store", "repository", repo.url, "branch", repo.branch)
+	return g, nil
+}
+
+// authEnv returns the environment that authenticates git commands with the credentials
+func (r *gitRepo) authEnv(creds map[string]string) ([]string, error) {
+	env := []string{
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME=" + gitCommitter, "GIT_AUTHOR_EMAIL=" + gitCommitterEmail,
+		"GIT_COMMITTER_NAME=" + gitCommitter, "GIT_COMMITTER_EMAIL=" + gitCommitterEmail,
+	}
+
+	switch {
+	case creds["token"] != "":
+		// Sent as a header, so that the token isn't written to the clone's config
+		username := creds["username"]
+		if username == "" {
+			username = "x-access-token"
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + creds["token"]))
+		env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0=Authorization: Basic "+auth)
+
+	case creds["sshPrivateKey"] != "":
+		if creds["knownHosts"] == "" {
+			return nil, fmt.Errorf("git credentials with an SSH private key require knownHosts")
+		}
+		sshDir := r.dir + ".ssh"
+		if err := os.MkdirAll(sshDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create SSH directory: %w", err)
+		}
+		key := filepath.Join(sshDir, "id")
+		knownHosts := filepath.Join(sshDir, "known_hosts")
+		if err := os.WriteFile(key, []byte(strings.TrimSpace(creds["sshPrivateKey"])+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write SSH private key: %w", err)
+		}
+		if err := os.WriteFile(knownHosts, []byte(creds["knownHosts"]), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write SSH known hosts: %w", err)
+		}
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o UserKnownHostsFile='%s' -o StrictHostKeyChecking=yes", key, knownHosts))
+	}
+	return env, nil
+}
+
+// git runs a git command in the clone and returns its output
+func (g *GitStore) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.repo.dir
+	cmd.Env = append(os.Environ(), g.env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// sync resets the clone to the remote branch, or to an empty branch if the remote branch doesn't exist yet
+func (g *GitStore) sync(ctx context.Context) error {
+	g.repo.synced = time.Time{}
+	if _, err := g.git(ctx, "fetch", "-q", "origin", g.repo.branch); err != nil {
+		heads, lsErr := g.git(ctx, "ls-remote", "--heads", "origin", g.repo.branch)
+		if lsErr != nil {
+			return err
+		}
+		if strings.TrimSpace(heads) == "" {
+			// Start the branch with the first commit
+			for _, args := range [][]string{{"update-ref", "-d", "HEAD"}, {"read-tree", "--empty"}, {"clean", "-qfdx"}} {
+				if _, err := g.git(ctx, args...); err != nil {
+					return err
+				}
+			}
+			g.repo.synced = time.Now()
+			return nil
+		}
+		// Another clone pushed the first commit since the fetch
+		if _, err := g.git(ctx, "fetch", "-q", "origin", g.repo.branch); err != nil {
+			return err
+		}
+	}
+	if _, err := g.git(ctx, "reset", "-q", "--hard", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	if _, err := g.git(ctx, "clean", "-qfdx"); err != nil {
+		return err
+	}
+	g.repo.synced = time.Now()
+	return nil
+}
+
+// refresh syncs the clone unless it was synced within the sync TTL
+func (g *GitStore) refresh(ctx context.Context) error {
+	if !g.repo.synced.IsZero() && time.Since(g.repo.synced) < g.repo.syncTTL {
+		return nil
+	}
+	return g.sync(ctx)
+}
+
+// filePath returns the path of a composition's file, relative to the clone. Each composition key
+// segment becomes a directory under the cluster directory, escaped to stay inside it.
+func (g *GitStore) filePath(clusterID, compositionKey string) string {
+	escape := func(s string) string {
+		switch s = url.PathEscape(s); s {
+		case "":
+			// PathEscape escapes '%', so this can't clash with a segment
+			return "%"
+		case ".", "..":
+			return strings.ReplaceAll(s, ".", "%2E")
+		}
+		return s
+	}
+	segments := []string{escape(clusterID)}
+	for _, s := range strings.Split(compositionKey, "/") {
+		segments = append(segments, escape(s))
+	}
+	return filepath.Join(segments...) + ".json"
+}
+
+// readFile reads the resource data of a composition from the clone
+func (g *GitStore) readFile(path string) (map[string]ResourceData, error) {
+	b, err := os.ReadFile(filepath.Join(g.repo.dir, path))
+	if os.IsNotExist(err) {
+		return make(map[string]ResourceData), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	f := backupFile{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+	if f.Resources == nil {
+		f.Resources = make(map[string]ResourceData)
+	}
+	return f.Resources, nil
+}
+
+// writeFile writes the resource data of a composition to the clone, removing the file if there is none
+func (g *GitStore) writeFile(path, compositionKey string, resources map[string]ResourceData) error {
+	full := filepath.Join(g.repo.dir, path)
+	if len(resources) == 0 {
+		if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(backupFile{CompositionKey: compositionKey, Resources: resources}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource data: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", path, err)
+	}
+	return os.WriteFile(full, append(b, '\n'), 0o600)
+}
+
+// change commits a change to a composition's file and pushes it. A rejected push is retried by
+// rebasing onto the remote branch, and a failed rebase by redoing the change on the remote branch.
+// The first attempt uses the clone without fetching if it was synced within the sync TTL, so that
+// writes that change nothing, like a save after a load, don't fetch.
+func (g *GitStore) change(ctx context.Context, action, clusterID, compositionKey string, update func(map[string]ResourceData) map[string]ResourceData) (err error) {
+	g.repo.mu.Lock()
+	defer g.repo.mu.Unlock()
+	defer func() {
+		if err != nil {
+			// The clone may hold an unpushed commit
+			g.repo.synced = time.Time{}
+		}
+	}()
+
+	path := g.filePath(clusterID, compositionKey)
+	message := fmt.Sprintf("%s %s in cluster %s\n\nComposition: %s\nCluster: %s\nTimestamp: %s\n",
+		action, compositeName(compositionKey), clusterID, compositionKey, clusterID, time.Now().UTC().Format(time.RFC3339))
+
+	var pushErr error
+	for attempt := 1; attempt <= gitPushAttempts; attempt++ {
+		syncClone := g.sync
+		if attempt == 1 {
+			syncClone = g.refresh
+		}
+		if err := syncClone(ctx); err != nil {
+			return err
+		}
+		resources, err := g.readFile(path)
+		if err != nil {
+			return err
+		}
+		if err := g.writeFile(path, compositionKey, update(resources)); err != nil {
+			return err
+		}
+		status, err := g.git(ctx, "status", "--porcelain", "--", path)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(status) == "" {
+			return nil
+		}
+		if _, err := g.git(ctx, "add", "-A", "--", path); err != nil {
+			return err
+		}
+		if _, err := g.git(ctx, "commit", "-q", "-m", message); err != nil {
+			return err
+		}
+
+		if pushErr = g.push(ctx); pushErr == nil {
+			g.repo.synced = time.Now()
+			g.log.Info("Committed resource data to git store", "composition-key", compositionKey, "cluster-id", clusterID, "attempt", attempt)
+			return nil
+		}
+		g.log.Debug("Push to git store rejected, retrying", "composition-key", compositionKey, "attempt", attempt, "error", pushErr.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("failed to push to git store after %d attempts: %w", gitPushAttempts, pushErr)
+}
+
+// push pushes the commit, rebasing onto the remote branch once if the push is rejected
+func (g *GitStore) push(ctx context.Context) error {
+	ref := "HEAD:refs/heads/" + g.repo.branch
+	if _, err := g.git(ctx, "push", "-q", "origin", ref); err == nil {
+		return nil
+	}
+	if _, err := g.git(ctx, "pull", "-q", "--rebase", "origin", g.repo.branch); err != nil {
+		_, _ = g.git(ctx, "rebase", "--abort")
+		return err
+	}
+	_, err := g.git(ctx, "push", "-q", "origin", ref)
+	return err
+}
+
+// compositeName returns the kind and name of the composite of a composition key, or the key
+// itself if it isn't a composite's
+func compositeName(compositionKey string) string {
+	p := strings.Split(compositionKey, "/")
+	if len(p) < 2 {
+		return compositionKey
+	}
+	return p[len(p)-2] + "/" + p[len(p)-1]
+}
+
+// Save stores resource data for an entire composition as a commit to the git store
+func (g *GitStore) Save(ctx context.Context, clusterID, compositionKey string, resources map[string]ResourceData) error {
+	return g.change(ctx, "Back up", clusterID, compositionKey, func(map[string]ResourceData) map[string]ResourceData {
+		return resources
+	})
+}
+
+// Load retrieves all resource data for a composition from the git store
+func (g *GitStore) Load(ctx context.Context, clusterID, compositionKey string) (map[string]ResourceData, error) {
+	g.repo.mu.Lock()
+	defer g.repo.mu.Unlock()
+
+	if err := g.refresh(ctx); err != nil {
+		return nil, err
+	}
+	resources, err := g.readFile(g.filePath(clusterID, compositionKey))
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("Loaded resource data from git store", "composition-key", compositionKey, "resource-count", len(resources))
+	return resources, nil
+}
+
+// DeleteResource removes a specific resource's data from a composition in the git store
+func (g *GitStore) DeleteResource(ctx context.Context, clusterID, compositionKey, resourceKey string) error {
+	return g.change(ctx, "Delete "+resourceKey+" of", clusterID, compositionKey, func(resources map[string]ResourceData) map[string]ResourceData {
+		delete(resources, resourceKey)
+		return resources
+	})
+}
+
+// Purge removes all resource data for a composition from the git store
+func (g *GitStore) Purge(ctx context.Context, clusterID, compositionKey string) error {
+	return g.change(ctx, "Purge", clusterID, compositionKey, func(map[string]ResourceData) map[string]ResourceData {
+		return nil
+	})
+}
+
+// ListCompositions retrieves the resource data of all compositions of a cluster from the git store
+func (g *GitStore) ListCompositions(ctx context.Context, clusterID string) (map[string]map[string]ResourceData, error) {
+	g.repo.mu.Lock()
+	defer g.repo.mu.Unlock()
+
+	if err := g.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	compositions := make(map[string]map[string]ResourceData)
+	root := filepath.Join(g.repo.dir, filepath.Dir(g.filePath(clusterID, "x")))
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return fs.SkipDir
+		}
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f := backupFile{}
+		if err := json.Unmarshal(b, &f); err != nil {
+			return fmt.Errorf("failed to unmarshal %s: %w", path, err)
+		}
+		compositions[f.CompositionKey] = f.Resources
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compositions in git store: %w", err)
+	}
+	return compositions, nil
+}
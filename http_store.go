@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// The HTTP store protocol, version v1. Composition keys and resource keys are a single path
+// segment each, with '/' escaped as %2F and the segments '.' and '..' escaped as %2E.
+//
+//	GET    {endpoint}/v1/clusters/{clusterID}/compositions                         list the compositions of a cluster
+//	GET    {endpoint}/v1/clusters/{clusterID}/compositions/{key}                   load a composition
+//	PUT    {endpoint}/v1/clusters/{clusterID}/compositions/{key}                   save a composition
+//	DELETE {endpoint}/v1/clusters/{clusterID}/compositions/{key}                   purge a composition
+//	DELETE {endpoint}/v1/clusters/{clusterID}/compositions/{key}/resources/{name}  delete a resource of a composition
+//
+// Bodies are JSON: an httpComposition for load and save, an httpCompositionList for list, and an
+// httpError for failed requests. A composition that doesn't exist loads as 200 with no resources,
+// or as 404. Writes respond 204. Composition bodies are limited to 4 MiB and list bodies to 256 MiB.
+const (
+	// HTTPStoreAPIVersion is the version of the HTTP store protocol
+	HTTPStoreAPIVersion = "v1"
+
+	// HTTPCredentialsName is the name of the function credentials of the HTTP store
+	HTTPCredentialsName = "http-creds"
+
+	// httpStoreTimeout bounds each request of the HTTP store
+	httpStoreTimeout = 30 * time.Second
+	// httpStoreMaxBody bounds the bodies read by the HTTP store and its server
+	httpStoreMaxBody = 4 << 20
+	// httpStoreMaxListBody bounds the list bodies read by the HTTP store, which hold all
+	// compositions of a cluster
+	httpStoreMaxListBody = 256 << 20
+)
+
+// httpComposition is the body of a composition in the HTTP store protocol
+type httpComposition struct {
+	CompositionKey string                  `json:"compositionKey,omitempty"`
+	Resources      map[string]ResourceData `json:"resources"`
+}
+
+// httpCompositionList is the body of the compositions of a cluster in the HTTP store protocol
+type httpCompositionList struct {
+	Compositions map[string]map[string]ResourceData `json:"compositions"`
+}
+
+// httpError is the body of a failed request in the HTTP store protocol
+type httpError struct {
+	Error string `json:"error"`
+}
+
+// HTTPStore implements ResourceStore with the HTTP store protocol
+type HTTPStore struct {
+	log      logging.Logger
+	client   *http.Client
+	endpoint string
+	token    string
+}
+
+// getHTTPCredentials retrieves the HTTP store credentials from the request (returns nil if not found).
+// They hold a bearer token, a client certificate (tls.crt, tls.key), or both, and optionally the CA
+// that verifies the server (ca.crt).
+func getHTTPCredentials(req *fnv1.RunFunctionRequest) map[string]string {
+	return getCredentialData(req, HTTPCredentialsName)
+}
+
+// NewHTTPStore creates an HTTP store for the base URL of a server of the HTTP store protocol
+func NewHTTPStore(_ context.Context, log logging.Logger, endpoint string, creds map[string]string) (*HTTPStore, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("no HTTP store endpoint configured, set %s", ConfigHTTPEndpoint)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP store endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid HTTP store endpoint %q: expected an http or https URL", endpoint)
+	}
+	if u.Scheme == "http" && len(creds) > 0 {
+		return nil, fmt.Errorf("refusing to send HTTP store credentials to %q over plain HTTP", endpoint)
+	}
+
+	tlsConfig, err := clientTLSConfig(creds)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &HTTPStore{
+		log:      log,
+		client:   &http.Client{Transport: transport, Timeout: httpStoreTimeout},
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    creds["token"],
+	}, nil
+}
+
+// httpPathSegment escapes a key as a single path segment of the HTTP store protocol
+func httpPathSegment(s string) string {
+	s = url.PathEscape(s)
+	if s == "." || s == ".." {
+		// Clients and servers would otherwise resolve them as relative paths
+		return strings.ReplaceAll(s, ".", "%2E")
+	}
+	return s
+}
+
+// compositionsPath returns the path of the compositions of a cluster, followed by the escaped segments
+func compositionsPath(clusterID string, segments ...string) string {
+	path := "/" + HTTPStoreAPIVersion + "/clusters/" + httpPathSegment(clusterID) + "/compositions"
+	for _, s := range segments {
+		path += "/" + httpPathSegment(s)
+	}
+	return path
+}
+
+// do sends a request of the HTTP store protocol, decoding the response into out, and returns the
+// response status. Responses larger than maxBody are an error.
+func (h *HTTPStore) do(ctx context.Context, method, path string, maxBody int64, body, out any) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.endpoint+path, r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	rsp, err := h.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer func() { _ = rsp.Body.Close() }()
+	b, err := io.ReadAll(io.LimitReader(rsp.Body, maxBody+1))
+	if err != nil {
+		return rsp.StatusCode, fmt.Errorf("%s %s failed to read response: %w", method, path, err)
+	}
+	if int64(len(b)) > maxBody {
+		return rsp.StatusCode, fmt.Errorf("%s %s failed: the response is larger than %d bytes", method, path, maxBody)
+	}
+
+	if rsp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return rsp.StatusCode, nil
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		msg := strings.TrimSpace(string(b))
+		e := httpError{}
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			msg = e.Error
+		}
+		return rsp.StatusCode, fmt.Errorf("%s %s failed with %s: %s", method, path, rsp.Status, msg)
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return rsp.StatusCode, fmt.Errorf("%s %s returned an invalid response: %w", method, path, err)
+		}
+	}
+	return rsp.StatusCode, nil
+}
+
+// Save stores resource data for an entire composition in the HTTP store
+func (h *HTTPStore) Save(ctx context.Context, clusterID, compositionKey string, resources map[string]ResourceData) error {
+	_, err := h.do(ctx, http.MethodPut, compositionsPath(clusterID, compositionKey), httpStoreMaxBody, httpComposition{CompositionKey: compositionKey, Resources: resources}, nil)
+	if err != nil {
+		return err
+	}
+	h.log.Debug("Saved resource data to HTTP store", "composition-key", compositionKey, "resource-count", len(resources))
+	return nil
+}
+
+// Load retrieves all resource data for a composition from the HTTP store
+func (h *HTTPStore) Load(ctx context.Context, clusterID, compositionKey string) (map[string]ResourceData, error) {
+	c := httpComposition{}
+	status, err := h.do(ctx, http.MethodGet, compositionsPath(clusterID, compositionKey), httpStoreMaxBody, nil, &c)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound || c.Resources == nil {
+		return make(map[string]ResourceData), nil
+	}
+	h.log.Debug("Loaded resource data from HTTP store", "composition-key", compositionKey, "resource-count", len(c.Resources))
+	return c.Resources, nil
+}
+
+// DeleteResource removes a specific resource's data from a composition in the HTTP store
+func (h *HTTPStore) DeleteResource(ctx context.Context, clusterID, compositionKey, resourceKey string) error {
+	_, err := h.do(ctx, http.MethodDelete, compositionsPath(clusterID, compositionKey, "resources", resourceKey), httpStoreMaxBody, nil, nil)
+	return err
+}
+
+// Purge removes all resource data for a composition from the HTTP store
+func (h *HTTPStore) Purge(ctx context.Context, clusterID, compositionKey string) error {
+	_, err := h.do(ctx, http.MethodDelete, compositionsPath(clusterID, compositionKey), httpStoreMaxBody, nil, nil)
+	return err
+}
+
+// ListCompositions retrieves the resource data of all compositions of a cluster from the HTTP store
+func (h *HTTPStore) ListCompositions(ctx context.Context, clusterID string) (map[string]map[string]ResourceData, error) {
+	l := httpCompositionList{}
+	status, err := h.do(ctx, http.MethodGet, compositionsPath(clusterID), httpStoreMaxListBody, nil, &l)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound || l.Compositions == nil {
+		return make(map[string]map[string]ResourceData), nil
+	}
+	return l.Compositions, nil
+}
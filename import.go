@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// xrdResource is the resource of Crossplane's composite resource definitions
+var xrdResource = schema.GroupVersionResource{Group: "apiextensions.crossplane.io", Version: "v1", Resource: "compositeresourcedefinitions"}
+
+// importSummary counts what an import did
+type importSummary struct {
+	composites   int
+	skipped      int
+	compositions int
+	stored       int
+	unchanged    int
+}
+
+// importer populates the store with the resource data of live composites and their composed resources
+type importer struct {
+	f      *Function
+	client dynamic.Interface
+	mapper meta.RESTMapper
+	store  ResourceStore
+	// config selects the store and cluster the import writes to, and the default backup scope
+	config *FunctionConfig
+	// in is the function input whose policy and backup selectors apply
+	in     *v1beta1.Input
+	dryRun bool
+	out    io.Writer
+}
+
+// compositeResources returns the resources of the composites defined by the cluster's composite
+// resource definitions, at their referenceable versions
+func compositeResources(ctx context.Context, client dynamic.Interface) ([]schema.GroupVersionResource, error) {
+	xrds, err := client.Resource(xrdResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list composite resource definitions")
+	}
+
+	var resources []schema.GroupVersionResource
+	for _, xrd := range xrds.Items {
+		group, _, _ := unstructured.NestedString(xrd.Object, "spec", "group")
+		plural, _, _ := unstructured.NestedString(xrd.Object, "spec", "names", "plural")
+		versions, _, _ := unstructured.NestedSlice(xrd.Object, "spec", "versions")
+		for _, v := range versions {
+			version, _ := v.(map[string]any)
+			if referenceable, _ := version["referenceable"].(bool); referenceable {
+				name, _ := version["name"].(string)
+				resources = append(resources, schema.GroupVersionResource{Group: group, Version: name, Resource: plural})
+			}
+		}
+	}
+	return resources, nil
+}
+
+// run imports the composites of the resources into the store and reports what it did
+func (i *importer) run(ctx context.Context, resources []schema.GroupVersionResource) (importSummary, error) {
+	summary := importSummary{}
+	if i.config.ClusterID == DefaultClusterID && !i.f.allowDefaultClusterID && !i.dryRun {
+		return summary, errors.Errorf("refusing to import into the external store under the %q cluster ID: set --cluster-id, or --allow-default-cluster-id", DefaultClusterID)
+	}
+	for _, r := range resources {
+		xrs, err := i.client.Resource(r).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return summary, errors.Wrapf(err, "cannot list composites of %s", r)
+		}
+		for idx := range xrs.Items {
+			summary.composites++
+			if err := i.importComposite(ctx, &xrs.Items[idx], &summary); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	verb := "Imported"
+	if i.dryRun {
+		verb = "Would import"
+	}
+	fmt.Fprintf(i.out, "%s %d composites into cluster %s: %d resources of %d compositions stored, %d unchanged, %d composites skipped\n",
+		verb, summary.composites, strconv.Quote(i.config.ClusterID), summary.stored, summary.compositions, summary.unchanged, summary.skipped)
+	return summary, nil
+}
+
+// importComposite merges the resource data of a composite's composed resources into the store
+func (i *importer) importComposite(ctx context.Context, xr *unstructured.Unstructured, summary *importSummary) error {
+	composite, err := structpb.NewStruct(xr.Object)
+	if err != nil {
+		return errors.Wrapf(err, "cannot convert composite %q", xr.GetName())
+	}
+
+	// Only import what the function would back up itself, into the store it would use
+	c, err := i.f.sweepComposite(ctx, i.store, i.config, i.in, sweptOwner{parts: compositeKeyParts(composite)}, composite)
+	if err != nil {
+		return err
+	}
+	compositionKey := c.compositionKey
+	if c.skip != "" {
+		summary.skipped++
+		fmt.Fprintf(i.out, "skipped %s: %s\n", compositionKey, c.skip)
+		return nil
+	}
+
+	live := make(map[string]ResourceData)
+	for _, ref := range compositeResourceRefs(xr) {
+		resource, err := i.getComposed(ctx, ref, xr.GetNamespace())
+		if err != nil {
+			return errors.Wrapf(err, "cannot get composed resource %s %q of composite %q", ref.Kind, ref.Name, xr.GetName())
+		}
+		if resource == nil {
+			continue
+		}
+		resourceName := getAnnotationValue(resource, CompositionResourceNameAnnotation)
+		if resourceName == "" || c.purged.matches(resourceName, ref.Kind) {
+			continue
+		}
+		if data := i.f.sweptResourceData(resource, resourceName, c.backupScope, i.in.Backup); data != (ResourceData{}) {
+			live[resourceName] = data
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+
+	stored, err := i.store.Load(ctx, i.config.ClusterID, compositionKey)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load resource data of composition %q from store", compositionKey)
+	}
+	merged, changed, unchanged := mergeResourceData(stored, live)
+	summary.unchanged += unchanged
+	if changed == 0 {
+		return nil
+	}
+
+	if !i.dryRun {
+		if err := i.store.Save(ctx, i.config.ClusterID, compositionKey, merged); err != nil {
+			return errors.Wrapf(err, "failed to save resource data of composition %q to store", compositionKey)
+		}
+	}
+	summary.stored += changed
+	summary.compositions++
+
+	verb := "imported"
+	if i.dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(i.out, "%s %s: %d resources stored, %d unchanged\n", verb, compositionKey, changed, unchanged)
+	for _, name := range slices.Sorted(maps.Keys(live)) {
+		if merged[name] != stored[name] {
+			fmt.Fprintf(i.out, "  %s: external name %q, resource name %q\n", name, merged[name].ExternalName, merged[name].ResourceName)
+		}
+	}
+	return nil
+}
+
+// compositeResourceRefs returns the references to a composite's composed resources, from
+// spec.crossplane.resourceRefs of Crossplane v2 composites or spec.resourceRefs of legacy ones
+func compositeResourceRefs(xr *unstructured.Unstructured) []corev1.ObjectReference {
+	refs, found, _ := unstructured.NestedSlice(xr.Object, "spec", "crossplane", "resourceRefs")
+	if !found {
+		refs, _, _ = unstructured.NestedSlice(xr.Object, "spec", "resourceRefs")
+	}
+	result := make([]corev1.ObjectReference, 0, len(refs))
+	for _, r := range refs {
+		m, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		ref := corev1.ObjectReference{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &ref); err == nil {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// getComposed gets a composed resource by reference, or nil if it doesn't exist. Namespaced
+// composites compose resources into their own namespace.
+func (i *importer) getComposed(ctx context.Context, ref corev1.ObjectReference, namespace string) (*structpb.Struct, error) {
+	gvk := ref.GroupVersionKind()
+	mapping, err := i.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var client dynamic.ResourceInterface = i.client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if ref.Namespace != "" {
+			namespace = ref.Namespace
+		}
+		client = i.client.Resource(mapping.Resource).Namespace(namespace)
+	}
+	u, err := client.Get(ctx, ref.Name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(u.Object)
+}
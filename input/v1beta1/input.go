@@ -11,9 +11,6 @@ import (
 // This isn't a custom resource, in the sense that we never install its CRD.
 // It is a KRM-like object, so we generate a CRD to describe its schema.
 
-// TODO: Add your input type here! It doesn't need to be called 'Input', you can
-// rename it to anything you like.
-
 // Input can be used to provide input to this Function.
 // +kubebuilder:object:root=true
 // +kubebuilder:storageversion
@@ -22,6 +19,314 @@ type Input struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	// This function is configured via environment variables and ConfigMap
-	// No input fields are needed
+	// Restore configures how resource data is looked up in the store.
+	// +optional
+	Restore *Restore `json:"restore,omitempty"`
+
+	// Policy restricts what XRs can configure through annotations.
+	// +optional
+	Policy *Policy `json:"policy,omitempty"`
+
+	// Backup selects composed resources that are backed up regardless of the
+	// backup scope, or never backed up.
+	// +optional
+	Backup *Backup `json:"backup,omitempty"`
+
+	// Status configures the backup summary written to the XR's status.
+	// +optional
+	Status *Status `json:"status,omitempty"`
+
+	// Config sets the function configuration for XRs using this composition.
+	// It overrides the function's flags and ConfigMap, and is overridden by
+	// XR annotations.
+	// +optional
+	Config *Config `json:"config,omitempty"`
+
+	// Sweep turns on sweep mode, for use in Operations and CronOperations.
+	// Instead of processing a composite, the function backs up the external
+	// names of the selected managed resources.
+	// +optional
+	Sweep *Sweep `json:"sweep,omitempty"`
+}
+
+// Sweep selects the managed resources to back up in sweep mode.
+type Sweep struct {
+	// Resources selects the managed resources to sweep. The function requests
+	// them from Crossplane as required resources.
+	Resources []SweepResource `json:"resources"`
+}
+
+// SweepResource selects managed resources of a kind.
+type SweepResource struct {
+	// APIVersion of the managed resources.
+	APIVersion string `json:"apiVersion"`
+
+	// Kind of the managed resources.
+	Kind string `json:"kind"`
+
+	// MatchLabels selects managed resources with these labels. All managed
+	// resources of the kind are selected if unset.
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// Config is the function configuration that can be set in the input.
+type Config struct {
+	// ClusterID the resource data is stored under.
+	// +optional
+	ClusterID string `json:"clusterId,omitempty"`
+
+	// ClusterIDSource to discover the cluster ID from, e.g. kube-system-uid.
+	// +optional
+	ClusterIDSource string `json:"clusterIdSource,omitempty"`
+
+	// StoreType is the type of external store: awsdynamodb, k8sconfigmap, git, http or plugin.
+	// +optional
+	StoreType string `json:"storeType,omitempty"`
+
+	// DynamoDBTable is the DynamoDB table name.
+	// +optional
+	DynamoDBTable string `json:"dynamodbTable,omitempty"`
+
+	// DynamoDBRegion is the DynamoDB region.
+	// +optional
+	DynamoDBRegion string `json:"dynamodbRegion,omitempty"`
+
+	// ConfigMapNamespace is the namespace of the ConfigMap store.
+	// +optional
+	ConfigMapNamespace string `json:"configMapNamespace,omitempty"`
+
+	// ConfigMapReads is how the ConfigMap store is read: api, or
+	// required-resources to have Crossplane supply the store ConfigMaps.
+	// +optional
+	ConfigMapReads string `json:"configMapReads,omitempty"`
+
+	// BackupScope is the backup scope: orphaned or all.
+	// +optional
+	BackupScope string `json:"backupScope,omitempty"`
+
+	// GitRepository is the URL of the repository of the git store.
+	// +optional
+	GitRepository string `json:"gitRepository,omitempty"`
+
+	// GitBranch is the branch of the git store.
+	// +optional
+	GitBranch string `json:"gitBranch,omitempty"`
+
+	// HTTPEndpoint is the base URL of the HTTP store.
+	// +optional
+	HTTPEndpoint string `json:"httpEndpoint,omitempty"`
+
+	// PluginAddress is the gRPC address of the store plugin, e.g. unix:///var/run/store.sock.
+	// +optional
+	PluginAddress string `json:"pluginAddress,omitempty"`
+}
+
+// Status configures the backup summary written to the XR's status.
+type Status struct {
+	// Field is the dot-separated path of the status field the summary is
+	// written to, e.g. status.externalNameBackup. It must start with
+	// "status." and be declared by the XRD. The summary is only written
+	// when a field is set.
+	// +optional
+	Field string `json:"field,omitempty"`
+}
+
+// Backup selects composed resources to back up or skip, overriding the
+// backup scope. The fn.crossplane.io/backup-policy annotation on a composed
+// resource takes precedence over these selectors.
+type Backup struct {
+	// Include selects resources that are backed up and restored regardless of
+	// the backup scope, for example Delete policy resources whose generated
+	// IDs should be recorded.
+	// +optional
+	Include []ResourceSelector `json:"include,omitempty"`
+
+	// Exclude selects resources that are never backed up or restored.
+	// Exclude takes precedence over Include.
+	// +optional
+	Exclude []ResourceSelector `json:"exclude,omitempty"`
+}
+
+// ResourceSelector selects composed resources. A resource is selected when
+// all fields that are set match.
+type ResourceSelector struct {
+	// APIVersion of the resource, may contain a single '*' wildcard, e.g.
+	// "ec2.aws.upbound.io/*".
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the resource, may contain a single '*' wildcard.
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// MatchLabels are labels the resource must have.
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// Policy restricts what XRs can configure through annotations, so that
+// tenants can't read or purge data that belongs to other tenants.
+type Policy struct {
+	// Overrides limits which values XRs may set on annotations that redirect
+	// where data is read or written, such as override-namespace or cluster-id.
+	// When set, these annotations are rejected unless a rule permits the value.
+	// +optional
+	Overrides []OverrideRule `json:"overrides,omitempty"`
+
+	// Store pins store settings. XR annotations can't change pinned settings.
+	// +optional
+	Store *StoreSettings `json:"store,omitempty"`
+
+	// Purge configures what is required to purge stored data.
+	// +optional
+	Purge *PurgePolicy `json:"purge,omitempty"`
+}
+
+// OverrideRule permits override annotation values for XRs in some namespaces.
+type OverrideRule struct {
+	// Namespaces the rule applies to, matched against the XR namespace for
+	// namespaced XRs, the claim namespace for cluster-scoped XRs of a claim,
+	// or "none" for other cluster-scoped XRs. Entries may contain a single '*'
+	// wildcard.
+	Namespaces []string `json:"namespaces"`
+
+	// AllowedValues maps an annotation to the values XRs in these namespaces
+	// may set it to. Values may contain a single '*' wildcard. Annotations that
+	// aren't listed can't be set.
+	// +optional
+	AllowedValues map[string][]string `json:"allowedValues,omitempty"`
+}
+
+// StoreSettings selects the store resource data is saved in.
+type StoreSettings struct {
+	// ClusterID under which resource data is stored.
+	// +optional
+	ClusterID string `json:"clusterId,omitempty"`
+
+	// StoreType is the type of store: awsdynamodb, k8sconfigmap, git, http, plugin or mock.
+	// +optional
+	StoreType string `json:"storeType,omitempty"`
+
+	// DynamoDBTable is the DynamoDB table name.
+	// +optional
+	DynamoDBTable string `json:"dynamodbTable,omitempty"`
+
+	// DynamoDBRegion is the AWS region of the DynamoDB table.
+	// +optional
+	DynamoDBRegion string `json:"dynamodbRegion,omitempty"`
+
+	// ConfigMapNamespace is the namespace of the ConfigMap store.
+	// +optional
+	ConfigMapNamespace string `json:"configMapNamespace,omitempty"`
+
+	// GitRepository is the URL of the repository of the git store.
+	// +optional
+	GitRepository string `json:"gitRepository,omitempty"`
+
+	// GitBranch is the branch of the git store.
+	// +optional
+	GitBranch string `json:"gitBranch,omitempty"`
+
+	// HTTPEndpoint is the base URL of the HTTP store.
+	// +optional
+	HTTPEndpoint string `json:"httpEndpoint,omitempty"`
+
+	// PluginAddress is the gRPC address of the store plugin, e.g. unix:///var/run/store.sock.
+	// +optional
+	PluginAddress string `json:"pluginAddress,omitempty"`
+}
+
+// PurgePolicy configures what is required to purge stored data.
+type PurgePolicy struct {
+	// ConfirmationToken must be set as the value of the
+	// fn.crossplane.io/purge-confirmation annotation for a purge to proceed.
+	// +optional
+	ConfirmationToken string `json:"confirmationToken,omitempty"`
+
+	// Retention is how long purged resource data is kept in a tombstone and
+	// can be undeleted. Defaults to 720h. Set to 0s to delete purged data
+	// immediately.
+	// +optional
+	Retention *metav1.Duration `json:"retention,omitempty"`
+}
+
+// Restore configures how resource data is looked up in the store.
+type Restore struct {
+	// FallbackKeys is an ordered list of alternative composition keys that are
+	// tried when no resource data exists under the current composition key.
+	// The first candidate with resource data is used for restoring.
+	// +optional
+	FallbackKeys []CompositionKeyCandidate `json:"fallbackKeys,omitempty"`
+
+	// CopyForward copies resource data found under a fallback key to the
+	// current composition key, so later reconciles no longer need the fallback.
+	// +optional
+	CopyForward bool `json:"copyForward,omitempty"`
+
+	// ResourceRenames maps stored resource names to the pipeline resource names
+	// they were renamed to, for example after a composition refactor. Mappings
+	// are applied in order when a desired resource has no data under its own name.
+	// +optional
+	ResourceRenames []ResourceRename `json:"resourceRenames,omitempty"`
+
+	// RewriteRenamedResources moves resource data used through a rename mapping
+	// to the new resource name in the store and removes the old entry.
+	// +optional
+	RewriteRenamedResources bool `json:"rewriteRenamedResources,omitempty"`
+
+	// AllowMissing lists desired resources that may lack stored data in
+	// restore-only mode, for example resources newly added to the composition.
+	// Entries are pipeline resource names, which may contain a single '*'
+	// wildcard, or "kind:<Kind>" entries.
+	// +optional
+	AllowMissing []string `json:"allowMissing,omitempty"`
+
+	// GeneratedNameResources selects resources whose external names are
+	// generated by the provider, such as VPC or subnet IDs. In the
+	// generated-names restore-only mode only these resources must have
+	// stored data.
+	// +optional
+	GeneratedNameResources []ResourceSelector `json:"generatedNameResources,omitempty"`
+}
+
+// ResourceRename maps a stored resource name to a new pipeline resource name.
+// Both names may contain a single '*' wildcard; the text matched by the
+// wildcard in To is substituted into From, e.g. "subnet-*" -> "network-subnet-*".
+type ResourceRename struct {
+	// From is the resource name the data is stored under.
+	From string `json:"from"`
+
+	// To is the pipeline resource name the data should be restored to.
+	To string `json:"to"`
+}
+
+// CompositionKeyCandidate describes an alternative composition key. Each
+// component that is left empty takes the value of the current composition key,
+// so a candidate only needs to name the components that differ.
+type CompositionKeyCandidate struct {
+	// ClusterID to load the candidate from.
+	// +optional
+	ClusterID string `json:"clusterId,omitempty"`
+
+	// Namespace component of the composition key, for example "none" for
+	// data backed up from v1 cluster-scoped XRs.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// ClaimName component of the composition key.
+	// +optional
+	ClaimName string `json:"claimName,omitempty"`
+
+	// APIVersion component of the composition key.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind component of the composition key.
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Name component of the composition key.
+	// +optional
+	Name string `json:"name,omitempty"`
 }
@@ -9,25 +9,84 @@ import (
 	"github.com/crossplane/function-sdk-go"
 )
 
+// Globals are flags shared by all commands.
+type Globals struct {
+	Debug bool `short:"d" help:"Emit debug logs in addition to info logs."`
+}
+
 // CLI of this Function.
 type CLI struct {
-	Debug bool `short:"d" help:"Emit debug logs in addition to info logs."`
+	Globals
+
+	Serve          ServeCmd          `cmd:"" default:"withargs" help:"Serve the Function (default)."`
+	PurgeResources PurgeResourcesCmd `cmd:"" help:"Purge the stored data of selected composed resources of a composition."`
+	Controller     ControllerCmd     `cmd:"" help:"Back up and restore the external names of managed resources that aren't composed."`
+	Import         ImportCmd         `cmd:"" help:"Populate the store with the resource data of the composites in a live cluster."`
+	Export         ExportCmd         `cmd:"" help:"Render the stored external names of a cluster as patches of their composites, for committing to Git."`
+	ServeStore     ServeStoreCmd     `cmd:"" help:"Serve the HTTP store protocol backed by another store, as a reference server."`
+	CheckPlugin    CheckPluginCmd    `cmd:"" help:"Run the conformance checks of the store plugin service against a running store plugin."`
+}
 
+// ServeCmd serves the Function.
+type ServeCmd struct {
 	Network            string `help:"Network on which to listen for gRPC connections." default:"tcp"`
 	Address            string `help:"Address at which to listen for gRPC connections." default:":9443"`
 	TLSCertsDir        string `help:"Directory containing server certs (tls.key, tls.crt) and the CA used to verify client certificates (ca.crt)" env:"TLS_SERVER_CERTS_DIR"`
 	Insecure           bool   `help:"Run without mTLS credentials. If you supply this flag --tls-server-certs-dir will be ignored."`
 	MaxRecvMessageSize int    `help:"Maximum size of received messages in MB." default:"4"`
+
+	ClusterIDSource       string `help:"Where to discover the cluster ID from for XRs without a cluster ID: 'kube-system-uid' or 'configmap:<namespace>/<name>'." env:"CLUSTER_ID_SOURCE"`
+	AllowDefaultClusterID bool   `help:"Allow writing to the external store under the 'default' cluster ID." env:"ALLOW_DEFAULT_CLUSTER_ID"`
+
+	// Defaults for XRs that don't configure these settings. Built-in defaults apply if unset.
+	ClusterID          string `help:"Cluster ID to store resource data under." env:"CLUSTER_ID"`
+	StoreType          string `help:"Type of external store: 'awsdynamodb', 'k8sconfigmap', 'git', 'http' or 'plugin'." env:"EXTERNAL_STORE_TYPE"`
+	DynamoDBTable      string `name:"dynamodb-table" help:"DynamoDB table name." env:"DYNAMODB_TABLE_NAME"`
+	DynamoDBRegion     string `name:"dynamodb-region" help:"DynamoDB region." env:"DYNAMODB_REGION"`
+	ConfigMapNamespace string `name:"configmap-namespace" help:"Namespace of the ConfigMap store." env:"CONFIGMAP_NAMESPACE"`
+	ConfigMapReads     string `name:"configmap-reads" help:"How the ConfigMap store is read: 'api' or 'required-resources'." env:"CONFIGMAP_READS"`
+	GitRepository      string `name:"git-repository" help:"URL of the git store repository." env:"GIT_REPOSITORY"`
+	GitBranch          string `name:"git-branch" help:"Branch of the git store repository." env:"GIT_BRANCH"`
+	HTTPEndpoint       string `name:"http-endpoint" help:"Base URL of the HTTP store." env:"HTTP_STORE_ENDPOINT"`
+	PluginAddress      string `name:"plugin-address" help:"gRPC address of the store plugin, e.g. 'unix:///var/run/store.sock'." env:"STORE_PLUGIN_ADDRESS"`
+	BackupScope        string `help:"Backup scope: 'orphaned' or 'all'." env:"BACKUP_SCOPE"`
+	ConfigMap          string `name:"config-map" help:"ConfigMap with configuration that overrides the flags, as '<namespace>/<name>'. Changes apply without a restart." env:"CONFIG_MAP"`
 }
 
 // Run this Function.
-func (c *CLI) Run() error {
-	log, err := function.NewLogger(c.Debug)
+func (c *ServeCmd) Run(g *Globals) error {
+	log, err := function.NewLogger(g.Debug)
 	if err != nil {
 		return err
 	}
 
-	fn := NewFunction(context.Background(), log)
+	ctx := context.Background()
+	opts := []FunctionOption{
+		WithConfigFlags(map[string]string{
+			ConfigClusterID:          c.ClusterID,
+			ConfigClusterIDSource:    c.ClusterIDSource,
+			ConfigStoreType:          c.StoreType,
+			ConfigDynamoDBTable:      c.DynamoDBTable,
+			ConfigDynamoDBRegion:     c.DynamoDBRegion,
+			ConfigConfigMapNamespace: c.ConfigMapNamespace,
+			ConfigConfigMapReads:     c.ConfigMapReads,
+			ConfigGitRepository:      c.GitRepository,
+			ConfigGitBranch:          c.GitBranch,
+			ConfigHTTPEndpoint:       c.HTTPEndpoint,
+			ConfigPluginAddress:      c.PluginAddress,
+			ConfigBackupScope:        c.BackupScope,
+		}),
+		WithAllowDefaultClusterID(c.AllowDefaultClusterID),
+	}
+	if c.ConfigMap != "" {
+		load, err := watchConfigMap(ctx, c.ConfigMap)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithConfigMap(load))
+	}
+
+	fn := NewFunction(ctx, log, opts...)
 
 	return function.Serve(fn,
 		function.Listen(c.Network, c.Address),
@@ -37,6 +96,7 @@ func (c *CLI) Run() error {
 }
 
 func main() {
-	ctx := kong.Parse(&CLI{}, kong.Description("A Crossplane Composition Function."))
-	ctx.FatalIfErrorf(ctx.Run())
+	cli := &CLI{}
+	ctx := kong.Parse(cli, kong.Description("A Crossplane Composition Function."))
+	ctx.FatalIfErrorf(ctx.Run(&cli.Globals))
 }
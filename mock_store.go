@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"maps"
 	"sync"
 
 	"github.com/crossplane/function-sdk-go/logging"
@@ -14,6 +15,9 @@ var testStoreRegistry *MockResourceStore
 type MockResourceStore struct {
 	mu   sync.RWMutex
 	data map[string]map[string]map[string]ResourceData // clusterID -> compositionKey -> resourceKey -> ResourceData
+
+	// deleteErr is returned by DeleteResource and Purge when set
+	deleteErr error
 }
 
 // NewMockStore creates a new MockResourceStore
@@ -73,11 +77,26 @@ func (m *MockResourceStore) Load(_ context.Context, clusterID, compositionKey st
 	return make(map[string]ResourceData), nil
 }
 
+// ListCompositions retrieves the resource data of all compositions of a cluster from the mock store
+func (m *MockResourceStore) ListCompositions(_ context.Context, clusterID string) (map[string]map[string]ResourceData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]map[string]ResourceData)
+	for compositionKey, compositionData := range m.data[clusterID] {
+		result[compositionKey] = maps.Clone(compositionData)
+	}
+	return result, nil
+}
+
 // DeleteResource removes a specific resource from the mock store
 func (m *MockResourceStore) DeleteResource(_ context.Context, clusterID, compositionKey, resourceKey string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.deleteErr != nil {
+		return m.deleteErr
+	}
 	if clusterData, exists := m.data[clusterID]; exists {
 		if compositionData, exists := clusterData[compositionKey]; exists {
 			delete(compositionData, resourceKey)
@@ -91,6 +110,9 @@ func (m *MockResourceStore) Purge(_ context.Context, clusterID, compositionKey s
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.deleteErr != nil {
+		return m.deleteErr
+	}
 	if clusterData, exists := m.data[clusterID]; exists {
 		delete(clusterData, compositionKey)
 	}
@@ -0,0 +1,330 @@
+// Package conformance checks that a store plugin serves the store plugin service the way the
+// function relies on. Plugin authors run it from their tests with Run, or against a running plugin
+// with the function's check-plugin command.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/crossplane/function-external-name-backup-restore/plugin/v1alpha1"
+)
+
+// checkTimeout bounds each check
+const checkTimeout = 30 * time.Second
+
+// A Check is a conformance check of a store plugin
+type Check struct {
+	// Name of the check
+	Name string
+	// Run returns why the plugin doesn't conform, or nil if it does
+	Run func(ctx context.Context, p *Plugin) error
+}
+
+// A Plugin is the store plugin a check runs against
+type Plugin struct {
+	Store  v1alpha1.StoreServiceClient
+	Health healthpb.HealthClient
+	// ClusterID is unique to the check, so that checks don't see data stored by other checks or runs
+	ClusterID string
+
+	// saved are the compositions saved by the check, by cluster ID, to purge after the check
+	saved map[string][]string
+}
+
+// Save saves a composition, and purges it after the check
+func (p *Plugin) Save(ctx context.Context, clusterID, compositionKey string, resources map[string]*v1alpha1.ResourceData) error {
+	if !slices.Contains(p.saved[clusterID], compositionKey) {
+		p.saved[clusterID] = append(p.saved[clusterID], compositionKey)
+	}
+	_, err := p.Store.Save(ctx, &v1alpha1.SaveRequest{ClusterId: clusterID, CompositionKey: compositionKey, Resources: resources})
+	if err != nil {
+		return fmt.Errorf("calling Save(%q, %q): %w", clusterID, compositionKey, err)
+	}
+	return nil
+}
+
+// Load loads a composition
+func (p *Plugin) Load(ctx context.Context, clusterID, compositionKey string) (map[string]*v1alpha1.ResourceData, error) {
+	rsp, err := p.Store.Load(ctx, &v1alpha1.LoadRequest{ClusterId: clusterID, CompositionKey: compositionKey})
+	if err != nil {
+		return nil, fmt.Errorf("calling Load(%q, %q): %w", clusterID, compositionKey, err)
+	}
+	return rsp.GetResources(), nil
+}
+
+// expectLoad loads a composition and compares its resource data
+func (p *Plugin) expectLoad(ctx context.Context, clusterID, compositionKey string, want map[string]*v1alpha1.ResourceData) error {
+	got, err := p.Load(ctx, clusterID, compositionKey)
+	if err != nil {
+		return err
+	}
+	if err := compare(want, got); err != nil {
+		return fmt.Errorf("calling Load(%q, %q): %w", clusterID, compositionKey, err)
+	}
+	return nil
+}
+
+// compare returns how the resource data differs from the expected resource data
+func compare(want, got map[string]*v1alpha1.ResourceData) error {
+	for _, name := range slices.Sorted(maps.Keys(want)) {
+		if g, ok := got[name]; !ok {
+			return fmt.Errorf("missing resource %q", name)
+		} else if !proto.Equal(want[name], g) {
+			return fmt.Errorf("resource %q: want %v, got %v", name, want[name], g)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(got)) {
+		if _, ok := want[name]; !ok {
+			return fmt.Errorf("unexpected resource %q", name)
+		}
+	}
+	return nil
+}
+
+// data returns resource data
+func data(externalName, resourceName string) *v1alpha1.ResourceData {
+	return &v1alpha1.ResourceData{ExternalName: externalName, ResourceName: resourceName}
+}
+
+// Checks returns the conformance checks
+func Checks() []Check {
+	const key = "default/my-claim/example.io/v1alpha1/XExample/my-xr"
+	bucket := data("bucket-abc", "my-xr-bucket")
+	vpc := data("vpc-123", "")
+
+	return []Check{
+		{
+			Name: "Healthy",
+			Run: func(ctx context.Context, p *Plugin) error {
+				rsp, err := p.Health.Check(ctx, &healthpb.HealthCheckRequest{Service: v1alpha1.StoreService_ServiceDesc.ServiceName})
+				if err != nil {
+					return fmt.Errorf("health check of %s: %w", v1alpha1.StoreService_ServiceDesc.ServiceName, err)
+				}
+				if rsp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+					return fmt.Errorf("health check of %s: want SERVING, got %s", v1alpha1.StoreService_ServiceDesc.ServiceName, rsp.GetStatus())
+				}
+				return nil
+			},
+		},
+		{
+			Name: "Capabilities",
+			Run: func(ctx context.Context, p *Plugin) error {
+				rsp, err := p.Store.GetCapabilities(ctx, &v1alpha1.GetCapabilitiesRequest{})
+				if err != nil {
+					return fmt.Errorf("calling GetCapabilities: %w", err)
+				}
+				if rsp.GetName() == "" {
+					return fmt.Errorf("calling GetCapabilities: want a plugin name")
+				}
+				for _, c := range rsp.GetCapabilities() {
+					if _, ok := v1alpha1.Capability_name[int32(c)]; !ok || c == v1alpha1.Capability_CAPABILITY_UNSPECIFIED {
+						return fmt.Errorf("calling GetCapabilities: unknown capability %s", c)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "LoadMissingComposition",
+			Run: func(ctx context.Context, p *Plugin) error {
+				return p.expectLoad(ctx, p.ClusterID, key, nil)
+			},
+		},
+		{
+			Name: "SaveAndLoad",
+			Run: func(ctx context.Context, p *Plugin) error {
+				resources := map[string]*v1alpha1.ResourceData{"bucket": bucket, "vpc": vpc, "xr": data("", "my-nested-xr")}
+				if err := p.Save(ctx, p.ClusterID, key, resources); err != nil {
+					return err
+				}
+				return p.expectLoad(ctx, p.ClusterID, key, resources)
+			},
+		},
+		{
+			Name: "SaveReplacesResources",
+			Run: func(ctx context.Context, p *Plugin) error {
+				if err := p.Save(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket, "vpc": vpc}); err != nil {
+					return err
+				}
+				replaced := map[string]*v1alpha1.ResourceData{"bucket": data("bucket-def", "my-xr-bucket")}
+				if err := p.Save(ctx, p.ClusterID, key, replaced); err != nil {
+					return err
+				}
+				return p.expectLoad(ctx, p.ClusterID, key, replaced)
+			},
+		},
+		{
+			Name: "DeleteResource",
+			Run: func(ctx context.Context, p *Plugin) error {
+				if err := p.Save(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket, "vpc": vpc}); err != nil {
+					return err
+				}
+				if _, err := p.Store.DeleteResource(ctx, &v1alpha1.DeleteResourceRequest{ClusterId: p.ClusterID, CompositionKey: key, ResourceKey: "vpc"}); err != nil {
+					return fmt.Errorf("calling DeleteResource: %w", err)
+				}
+				return p.expectLoad(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket})
+			},
+		},
+		{
+			Name: "DeleteMissingResource",
+			Run: func(ctx context.Context, p *Plugin) error {
+				if _, err := p.Store.DeleteResource(ctx, &v1alpha1.DeleteResourceRequest{ClusterId: p.ClusterID, CompositionKey: key, ResourceKey: "vpc"}); err != nil {
+					return fmt.Errorf("calling DeleteResource of a missing composition: %w", err)
+				}
+				if err := p.Save(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket}); err != nil {
+					return err
+				}
+				if _, err := p.Store.DeleteResource(ctx, &v1alpha1.DeleteResourceRequest{ClusterId: p.ClusterID, CompositionKey: key, ResourceKey: "vpc"}); err != nil {
+					return fmt.Errorf("calling DeleteResource of a missing resource: %w", err)
+				}
+				return p.expectLoad(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket})
+			},
+		},
+		{
+			Name: "Purge",
+			Run: func(ctx context.Context, p *Plugin) error {
+				other := "none/none/example.io/v1alpha1/XExample/other-xr"
+				if err := p.Save(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket}); err != nil {
+					return err
+				}
+				if err := p.Save(ctx, p.ClusterID, other, map[string]*v1alpha1.ResourceData{"vpc": vpc}); err != nil {
+					return err
+				}
+				if _, err := p.Store.Purge(ctx, &v1alpha1.PurgeRequest{ClusterId: p.ClusterID, CompositionKey: key}); err != nil {
+					return fmt.Errorf("calling Purge: %w", err)
+				}
+				if err := p.expectLoad(ctx, p.ClusterID, key, nil); err != nil {
+					return err
+				}
+				return p.expectLoad(ctx, p.ClusterID, other, map[string]*v1alpha1.ResourceData{"vpc": vpc})
+			},
+		},
+		{
+			Name: "PurgeMissingComposition",
+			Run: func(ctx context.Context, p *Plugin) error {
+				if _, err := p.Store.Purge(ctx, &v1alpha1.PurgeRequest{ClusterId: p.ClusterID, CompositionKey: key}); err != nil {
+					return fmt.Errorf("calling Purge of a missing composition: %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "ClustersAreIsolated",
+			Run: func(ctx context.Context, p *Plugin) error {
+				other := p.ClusterID + "-other"
+				if err := p.Save(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket}); err != nil {
+					return err
+				}
+				if err := p.Save(ctx, other, key, map[string]*v1alpha1.ResourceData{"vpc": vpc}); err != nil {
+					return err
+				}
+				if _, err := p.Store.Purge(ctx, &v1alpha1.PurgeRequest{ClusterId: other, CompositionKey: key}); err != nil {
+					return fmt.Errorf("calling Purge: %w", err)
+				}
+				return p.expectLoad(ctx, p.ClusterID, key, map[string]*v1alpha1.ResourceData{"bucket": bucket})
+			},
+		},
+		{
+			Name: "KeysAreOpaque",
+			Run: func(ctx context.Context, p *Plugin) error {
+				// Keys that are prefixes of each other, or contain separators, must not collide
+				keys := []string{"a/b", "a/b/c", "a%2Fb", "../a", "a/b#tombstone", "ü/ñ 1", "standalone/none/s3.aws.upbound.io/Bucket/b"}
+				for i, k := range keys {
+					resources := map[string]*v1alpha1.ResourceData{fmt.Sprintf("r/%d #ü", i): data(fmt.Sprintf("name-%d", i), "")}
+					if err := p.Save(ctx, p.ClusterID, k, resources); err != nil {
+						return err
+					}
+				}
+				for i, k := range keys {
+					if err := p.expectLoad(ctx, p.ClusterID, k, map[string]*v1alpha1.ResourceData{fmt.Sprintf("r/%d #ü", i): data(fmt.Sprintf("name-%d", i), "")}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "ListCompositions",
+			Run: func(ctx context.Context, p *Plugin) error {
+				caps, err := p.Store.GetCapabilities(ctx, &v1alpha1.GetCapabilitiesRequest{})
+				if err != nil {
+					return fmt.Errorf("calling GetCapabilities: %w", err)
+				}
+				if !slices.Contains(caps.GetCapabilities(), v1alpha1.Capability_CAPABILITY_LIST_COMPOSITIONS) {
+					return nil
+				}
+
+				other := "none/none/example.io/v1alpha1/XExample/other-xr"
+				want := map[string]map[string]*v1alpha1.ResourceData{key: {"bucket": bucket}, other: {"vpc": vpc}}
+				for _, k := range slices.Sorted(maps.Keys(want)) {
+					if err := p.Save(ctx, p.ClusterID, k, want[k]); err != nil {
+						return err
+					}
+				}
+				rsp, err := p.Store.ListCompositions(ctx, &v1alpha1.ListCompositionsRequest{ClusterId: p.ClusterID})
+				if err != nil {
+					return fmt.Errorf("calling ListCompositions: %w", err)
+				}
+				if got := slices.Sorted(maps.Keys(rsp.GetCompositions())); !slices.Equal(got, slices.Sorted(maps.Keys(want))) {
+					return fmt.Errorf("calling ListCompositions: want compositions %q, got %q", slices.Sorted(maps.Keys(want)), got)
+				}
+				for k, resources := range want {
+					if err := compare(resources, rsp.GetCompositions()[k].GetResources()); err != nil {
+						return fmt.Errorf("calling ListCompositions: composition %q: %w", k, err)
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// RunCheck runs a check against the store plugin served on the connection, under a unique cluster
+// ID, and purges what the check saved
+func RunCheck(ctx context.Context, conn grpc.ClientConnInterface, c Check) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	p := &Plugin{
+		Store:     v1alpha1.NewStoreServiceClient(conn),
+		Health:    healthpb.NewHealthClient(conn),
+		ClusterID: "conformance-" + hex.EncodeToString(suffix),
+		saved:     make(map[string][]string),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	err := c.Run(ctx, p)
+	for clusterID, keys := range p.saved {
+		for _, k := range keys {
+			if _, perr := p.Store.Purge(ctx, &v1alpha1.PurgeRequest{ClusterId: clusterID, CompositionKey: k}); perr != nil && err == nil {
+				err = fmt.Errorf("calling Purge(%q, %q) after the check: %w", clusterID, k, perr)
+			}
+		}
+	}
+	return err
+}
+
+// Run runs the conformance checks against the store plugin served on the connection, each as a subtest
+func Run(t *testing.T, conn grpc.ClientConnInterface) {
+	t.Helper()
+	for _, c := range Checks() {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := RunCheck(context.Background(), conn, c); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build generate
+// +build generate
+
+// Generate the store plugin service from its protobuf definition. Requires protoc,
+// protoc-gen-go and protoc-gen-go-grpc on the PATH.
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ../plugin/v1alpha1/store.proto
+
+// Package plugin holds the store plugin service, implemented by out-of-process stores.
+package plugin
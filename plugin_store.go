@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+
+	pluginv1alpha1 "github.com/crossplane/function-external-name-backup-restore/plugin/v1alpha1"
+)
+
+const (
+	// PluginCredentialsName is the name of the function credentials of the plugin store
+	PluginCredentialsName = "plugin-creds"
+
+	// pluginTimeout bounds each call of the plugin store
+	pluginTimeout = 30 * time.Second
+)
+
+// pluginConns are the connections to store plugins, shared by the function's runs
+var pluginConns = struct {
+	mu    sync.Mutex
+	conns map[string]*pluginConn
+}{conns: make(map[string]*pluginConn)}
+
+// pluginConn is a connection to a healthy store plugin and what the plugin told about itself
+type pluginConn struct {
+	conn         *grpc.ClientConn
+	name         string
+	capabilities []pluginv1alpha1.Capability
+}
+
+// PluginStore implements ResourceStore with a store plugin, an out-of-process server of the
+// store plugin service, e.g. a sidecar
+type PluginStore struct {
+	log    logging.Logger
+	client pluginv1alpha1.StoreServiceClient
+	plugin *pluginConn
+	// id is the key of the plugin's connection in pluginConns
+	id string
+}
+
+// getPluginCredentials retrieves the plugin store credentials from the request (returns nil if not found).
+// They hold the CA that verifies the plugin (ca.crt) and optionally a client certificate (tls.crt, tls.key).
+func getPluginCredentials(req *fnv1.RunFunctionRequest) map[string]string {
+	return getCredentialData(req, PluginCredentialsName)
+}
+
+// NewPluginStore creates a plugin store for the plugin at a gRPC address. Connections are shared by
+// the function's runs, the plugin's health and capabilities are checked when it is first connected.
+// A connection is dropped when the plugin is unavailable or lacks a call or capability, so that the
+// next run reconnects, e.g. to a plugin that restarted with other capabilities. Without credentials,
+// the plugin is connected to without TLS.
+func NewPluginStore(ctx context.Context, log logging.Logger, address string, creds map[string]string) (*PluginStore, error) {
+	if address == "" {
+		return nil, fmt.Errorf("no store plugin address configured, set %s", ConfigPluginAddress)
+	}
+
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(creds)) {
+		fmt.Fprintf(h, "%s=%s\n", k, creds[k])
+	}
+	id := fmt.Sprintf("%s#%x", address, h.Sum(nil))
+
+	pluginConns.mu.Lock()
+	plugin, ok := pluginConns.conns[id]
+	pluginConns.mu.Unlock()
+	if !ok {
+		// Connect without holding the lock, so that a slow plugin doesn't block the runs of other plugins
+		connected, err := connectPlugin(ctx, address, creds)
+		if err != nil {
+			return nil, err
+		}
+		pluginConns.mu.Lock()
+		if plugin, ok = pluginConns.conns[id]; !ok {
+			plugin = connected
+			pluginConns.conns[id] = plugin
+		}
+		pluginConns.mu.Unlock()
+		if ok {
+			// Another run connected first
+			_ = connected.conn.Close()
+		} else {
+			log.Info("Connected to store plugin", "address", address, "plugin", plugin.name, "capabilities", fmt.Sprint(plugin.capabilities))
+		}
+	}
+	return &PluginStore{log: log, client: pluginv1alpha1.NewStoreServiceClient(plugin.conn), plugin: plugin, id: id}, nil
+}
+
+// disconnect drops the plugin's shared connection, so that the next run reconnects and checks the
+// plugin's health and capabilities again
+func (p *PluginStore) disconnect(reason string) {
+	pluginConns.mu.Lock()
+	current := pluginConns.conns[p.id] == p.plugin
+	if current {
+		delete(pluginConns.conns, p.id)
+	}
+	pluginConns.mu.Unlock()
+	if current {
+		_ = p.plugin.conn.Close()
+		p.log.Info("Disconnected from store plugin", "plugin", p.plugin.name, "reason", reason)
+	}
+}
+
+// failed disconnects from the plugin if a call failed because the plugin is unavailable or doesn't
+// implement the call, and returns the error
+func (p *PluginStore) failed(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Unimplemented:
+		p.disconnect(err.Error())
+	}
+	return err
+}
+
+// dialPlugin creates a connection to a store plugin, with TLS if there are credentials
+func dialPlugin(address string, creds map[string]string) (*grpc.ClientConn, error) {
+	transport := insecure.NewCredentials()
+	if len(creds) > 0 {
+		tlsConfig, err := clientTLSConfig(creds)
+		if err != nil {
+			return nil, err
+		}
+		transport = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client of store plugin %q: %w", address, err)
+	}
+	return conn, nil
+}
+
+// connectPlugin connects to a store plugin, and checks that it serves the store plugin service
+func connectPlugin(ctx context.Context, address string, creds map[string]string) (*pluginConn, error) {
+	conn, err := dialPlugin(address, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: pluginv1alpha1.StoreService_ServiceDesc.ServiceName})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to check health of store plugin %q: %w", address, err)
+	}
+	if health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		_ = conn.Close()
+		return nil, fmt.Errorf("store plugin %q is %s", address, health.GetStatus())
+	}
+	caps, err := pluginv1alpha1.NewStoreServiceClient(conn).GetCapabilities(ctx, &pluginv1alpha1.GetCapabilitiesRequest{})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to get capabilities of store plugin %q: %w", address, err)
+	}
+	return &pluginConn{conn: conn, name: caps.GetName(), capabilities: caps.GetCapabilities()}, nil
+}
+
+// toPluginResources converts resource data to the store plugin service's
+func toPluginResources(resources map[string]ResourceData) map[string]*pluginv1alpha1.ResourceData {
+	result := make(map[string]*pluginv1alpha1.ResourceData, len(resources))
+	for k, v := range resources {
+		result[k] = &pluginv1alpha1.ResourceData{ExternalName: v.ExternalName, ResourceName: v.ResourceName}
+	}
+	return result
+}
+
+// fromPluginResources converts resource data of the store plugin service
+func fromPluginResources(resources map[string]*pluginv1alpha1.ResourceData) map[string]ResourceData {
+	result := make(map[string]ResourceData, len(resources))
+	for k, v := range resources {
+		result[k] = ResourceData{ExternalName: v.GetExternalName(), ResourceName: v.GetResourceName()}
+	}
+	return result
+}
+
+// Save stores resource data for an entire composition in the store plugin
+func (p *PluginStore) Save(ctx context.Context, clusterID, compositionKey string, resources map[string]ResourceData) error {
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	_, err := p.client.Save(ctx, &pluginv1alpha1.SaveRequest{ClusterId: clusterID, CompositionKey: compositionKey, Resources: toPluginResources(resources)})
+	if err != nil {
+		return fmt.Errorf("store plugin %s failed to save: %w", p.plugin.name, p.failed(err))
+	}
+	p.log.Debug("Saved resource data to store plugin", "composition-key", compositionKey, "resource-count", len(resources))
+	return nil
+}
+
+// Load retrieves all resource data for a composition from the store plugin
+func (p *PluginStore) Load(ctx context.Context, clusterID, compositionKey string) (map[string]ResourceData, error) {
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	rsp, err := p.client.Load(ctx, &pluginv1alpha1.LoadRequest{ClusterId: clusterID, CompositionKey: compositionKey})
+	if err != nil {
+		return nil, fmt.Errorf("store plugin %s failed to load: %w", p.plugin.name, p.failed(err))
+	}
+	return fromPluginResources(rsp.GetResources()), nil
+}
+
+// DeleteResource removes a specific resource's data from a composition in the store plugin
+func (p *PluginStore) DeleteResource(ctx context.Context, clusterID, compositionKey, resourceKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	_, err := p.client.DeleteResource(ctx, &pluginv1alpha1.DeleteResourceRequest{ClusterId: clusterID, CompositionKey: compositionKey, ResourceKey: resourceKey})
+	if err != nil {
+		return fmt.Errorf("store plugin %s failed to delete resource: %w", p.plugin.name, p.failed(err))
+	}
+	return nil
+}
+
+// Purge removes all resource data for a composition from the store plugin
+func (p *PluginStore) Purge(ctx context.Context, clusterID, compositionKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	if _, err := p.client.Purge(ctx, &pluginv1alpha1.PurgeRequest{ClusterId: clusterID, CompositionKey: compositionKey}); err != nil {
+		return fmt.Errorf("store plugin %s failed to purge: %w", p.plugin.name, p.failed(err))
+	}
+	return nil
+}
+
+// ListCompositions retrieves the resource data of all compositions of a cluster from the store
+// plugin, if the plugin supports it
+func (p *PluginStore) ListCompositions(ctx context.Context, clusterID string) (map[string]map[string]ResourceData, error) {
+	if !slices.Contains(p.plugin.capabilities, pluginv1alpha1.Capability_CAPABILITY_LIST_COMPOSITIONS) {
+		// The plugin may have gained the capability since it was connected
+		p.disconnect("missing capability " + pluginv1alpha1.Capability_CAPABILITY_LIST_COMPOSITIONS.String())
+		return nil, fmt.Errorf("store plugin %s cannot list its compositions", p.plugin.name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	rsp, err := p.client.ListCompositions(ctx, &pluginv1alpha1.ListCompositionsRequest{ClusterId: clusterID})
+	if err != nil {
+		return nil, fmt.Errorf("store plugin %s failed to list compositions: %w", p.plugin.name, p.failed(err))
+	}
+	compositions := make(map[string]map[string]ResourceData, len(rsp.GetCompositions()))
+	for key, c := range rsp.GetCompositions() {
+		compositions[key] = fromPluginResources(c.GetResources())
+	}
+	return compositions, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"slices"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// policyGuardedAnnotations are the annotations that redirect where resource data is
+// read or written. When override rules are configured they may only be set to permitted values.
+var policyGuardedAnnotations = []string{
+	ClusterIDAnnotation,
+	ClusterIDSourceAnnotation,
+	RestoreClusterIDAnnotation,
+	AllowDefaultClusterIDAnnotation,
+	OverrideNamespaceAnnotation,
+	OverrideClaimNameAnnotation,
+	OverrideAPIVersionAnnotation,
+	OverrideKindAnnotation,
+	OverrideNameAnnotation,
+	OverrideCompositionKeyAnnotation,
+}
+
+// compositeAnnotationValues returns the distinct non-empty values of an annotation on the observed and desired composite.
+// Policies check both, because different code paths prefer one over the other.
+func compositeAnnotationValues(req *fnv1.RunFunctionRequest, annotation string) []string {
+	var values []string
+	for _, composite := range []*fnv1.Resource{req.GetObserved().GetComposite(), req.GetDesired().GetComposite()} {
+		if composite.GetResource() == nil {
+			continue
+		}
+		if v := getAnnotationValue(composite.GetResource(), annotation); v != "" && !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// matchesAny reports whether value matches any of the patterns, which may contain a single '*' wildcard
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if _, ok := matchWildcard(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyStorePolicy pins store settings in the config, rejecting XR annotations that set a different value
+func applyStorePolicy(req *fnv1.RunFunctionRequest, policy *v1beta1.Policy, config *FunctionConfig) error {
+	if policy == nil || policy.Store == nil {
+		return nil
+	}
+
+	pinned := []struct {
+		name       string
+		annotation string
+		value      string
+		setting    *string
+	}{
+		{name: ConfigClusterID, annotation: ClusterIDAnnotation, value: policy.Store.ClusterID, setting: &config.ClusterID},
+		{name: ConfigStoreType, annotation: StoreTypeAnnotation, value: policy.Store.StoreType, setting: &config.StoreType},
+		{name: ConfigDynamoDBTable, annotation: DynamoDBTableAnnotation, value: policy.Store.DynamoDBTable, setting: &config.DynamoDBTable},
+		{name: ConfigDynamoDBRegion, annotation: DynamoDBRegionAnnotation, value: policy.Store.DynamoDBRegion, setting: &config.DynamoDBRegion},
+		{name: ConfigConfigMapNamespace, annotation: ConfigMapNamespaceAnnotation, value: policy.Store.ConfigMapNamespace, setting: &config.ConfigMapNamespace},
+		{name: ConfigGitRepository, value: policy.Store.GitRepository, setting: &config.GitRepository},
+		{name: ConfigGitBranch, annotation: GitBranchAnnotation, value: policy.Store.GitBranch, setting: &config.GitBranch},
+		{name: ConfigHTTPEndpoint, value: policy.Store.HTTPEndpoint, setting: &config.HTTPEndpoint},
+		{name: ConfigPluginAddress, value: policy.Store.PluginAddress, setting: &config.PluginAddress},
+	}
+	for _, p := range pinned {
+		if p.value == "" {
+			continue
+		}
+		for _, v := range compositeAnnotationValues(req, p.annotation) {
+			if v != p.value {
+				return errors.Errorf("policy violation: annotation %s is pinned to %q by the function input and can't be set to %q", p.annotation, p.value, v)
+			}
+		}
+		*p.setting = p.value
+		config.Sources[p.name] = ConfigSourcePolicy
+	}
+
+	// A pinned cluster ID must not be replaced by discovery
+	if policy.Store.ClusterID != "" {
+		config.ClusterIDSource = ""
+		delete(config.Sources, ConfigClusterIDSource)
+	}
+	return nil
+}
+
+// policyNamespace returns the namespace the override policy is applied to a composite in, and the
+// claim namespace label it carries. Anyone who can create a namespaced XR can set its labels, so a
+// namespaced XR is in its own namespace. Only cluster-scoped composites are in their claim's.
+func policyNamespace(composite *structpb.Struct) (namespace, claimNamespace string) {
+	metadata := composite.GetFields()["metadata"].GetStructValue().GetFields()
+	claimNamespace = metadata["labels"].GetStructValue().GetFields()["crossplane.io/claim-namespace"].GetStringValue()
+	if ns := metadata["namespace"].GetStringValue(); ns != "" {
+		return ns, claimNamespace
+	}
+	if claimNamespace != "" {
+		return claimNamespace, claimNamespace
+	}
+	return "none", claimNamespace
+}
+
+// checkOverridePolicy rejects override annotations that no override rule permits for the XR's
+// namespace, and namespaced XRs whose claim namespace label names another namespace, because the
+// label would otherwise redirect their composition key
+func checkOverridePolicy(req *fnv1.RunFunctionRequest, policy *v1beta1.Policy) error {
+	if policy == nil || len(policy.Overrides) == 0 {
+		return nil
+	}
+
+	namespace, claimNamespace := policyNamespace(req.GetObserved().GetComposite().GetResource())
+	if claimNamespace != "" && claimNamespace != namespace {
+		return errors.Errorf("policy violation: label crossplane.io/claim-namespace=%q doesn't match the XR's namespace %q", claimNamespace, namespace)
+	}
+
+	var rule *v1beta1.OverrideRule
+	for i := range policy.Overrides {
+		if matchesAny(policy.Overrides[i].Namespaces, namespace) {
+			rule = &policy.Overrides[i]
+			break
+		}
+	}
+
+	for _, annotation := range policyGuardedAnnotations {
+		for _, v := range compositeAnnotationValues(req, annotation) {
+			if rule == nil {
+				return errors.Errorf("policy violation: annotation %s is not permitted for XRs in namespace %q", annotation, namespace)
+			}
+			if !matchesAny(rule.AllowedValues[annotation], v) {
+				return errors.Errorf("policy violation: annotation %s=%q is not permitted for XRs in namespace %q", annotation, v, namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPurgePolicy rejects purges that don't carry the confirmation token required by the purge policy
+func checkPurgePolicy(req *fnv1.RunFunctionRequest, policy *v1beta1.Policy) error {
+	if policy == nil || policy.Purge == nil || policy.Purge.ConfirmationToken == "" {
+		return nil
+	}
+	values := compositeAnnotationValues(req, PurgeConfirmationAnnotation)
+	if len(values) == 0 {
+		return errors.Errorf("policy violation: purging requires the %s annotation to be set to the confirmation token", PurgeConfirmationAnnotation)
+	}
+	for _, v := range values {
+		if v != policy.Purge.ConfirmationToken {
+			return errors.Errorf("policy violation: the %s annotation doesn't match the confirmation token", PurgeConfirmationAnnotation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// resourceRename maps a stored resource name to the pipeline resource name it was renamed to
+type resourceRename struct {
+	From string
+	To   string
+}
+
+// parseResourceRenames parses a comma-separated list of oldName=newName mappings
+func parseResourceRenames(value string) ([]resourceRename, error) {
+	var renames []resourceRename
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid resource rename %q: expected oldName=newName", entry)
+		}
+		renames = append(renames, resourceRename{
+			From: strings.TrimSpace(parts[0]),
+			To:   strings.TrimSpace(parts[1]),
+		})
+	}
+	return renames, nil
+}
+
+// getResourceRenames combines the rename mappings from the XR annotation and the function input.
+// Annotation mappings are listed first so they take precedence.
+func getResourceRenames(annotationValue string, in *v1beta1.Input) ([]resourceRename, error) {
+	renames, err := parseResourceRenames(annotationValue)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s annotation", ResourceRenamesAnnotation)
+	}
+	if in.Restore != nil {
+		for _, r := range in.Restore.ResourceRenames {
+			renames = append(renames, resourceRename{From: r.From, To: r.To})
+		}
+	}
+
+	for _, r := range renames {
+		if r.From == "" || r.To == "" {
+			return nil, errors.Errorf("invalid resource rename %q -> %q: both names are required", r.From, r.To)
+		}
+		if strings.Count(r.From, "*") > 1 || strings.Count(r.To, "*") > 1 {
+			return nil, errors.Errorf("invalid resource rename %q -> %q: at most one '*' is supported", r.From, r.To)
+		}
+		if strings.Contains(r.From, "*") != strings.Contains(r.To, "*") {
+			return nil, errors.Errorf("invalid resource rename %q -> %q: either both or neither name must contain '*'", r.From, r.To)
+		}
+	}
+	return renames, nil
+}
+
+// matchWildcard matches name against a pattern containing at most one '*' and returns the text matched by '*'
+func matchWildcard(pattern, name string) (string, bool) {
+	prefix, suffix, hasWildcard := strings.Cut(pattern, "*")
+	if !hasWildcard {
+		return "", pattern == name
+	}
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// oldNameFor returns the stored resource name that maps to the given pipeline resource name
+func (r resourceRename) oldNameFor(newName string) (string, bool) {
+	captured, ok := matchWildcard(r.To, newName)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(r.From, "*", captured, 1), true
+}
+
+// applyResourceRenames adds entries for renamed pipeline resources to the resource data.
+// Only resources without data under their own name are considered, and the first
+// matching mapping whose old name has data wins. It returns the mappings used as newName -> oldName.
+func applyResourceRenames(resources map[string]ResourceData, resourceNames []string, renames []resourceRename) map[string]string {
+	used := make(map[string]string)
+	if len(renames) == 0 {
+		return used
+	}
+
+	// Look up old names before adding any entries, so that a renamed entry is
+	// never picked up again by another mapping
+	sort.Strings(resourceNames)
+	for _, name := range resourceNames {
+		if _, exists := resources[name]; exists {
+			continue
+		}
+		for _, r := range renames {
+			oldName, ok := r.oldNameFor(name)
+			if !ok || oldName == name {
+				continue
+			}
+			if _, exists := resources[oldName]; exists {
+				used[name] = oldName
+				break
+			}
+		}
+	}
+
+	for newName, oldName := range used {
+		resources[newName] = resources[oldName]
+	}
+	return used
+}
+
+// rewriteRenamedResources moves stored entries to the new names of the mappings used.
+// Old entries are removed unless they still belong to a pipeline resource.
+// It returns the number of entries written under a new name.
+func rewriteRenamedResources(resources map[string]ResourceData, used map[string]string, resourceNames []string) int {
+	rewritten := 0
+	for newName, oldName := range used {
+		if data, exists := resources[oldName]; exists {
+			resources[newName] = data
+			rewritten++
+		}
+	}
+	for _, oldName := range used {
+		if !slices.Contains(resourceNames, oldName) {
+			delete(resources, oldName)
+		}
+	}
+	return rewritten
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	// BackupHealthyConditionType is the XR condition reporting whether resource data is backed up
+	BackupHealthyConditionType = "BackupHealthy"
+	// RestoreCompleteConditionType is the XR condition reporting whether all resources could be restored
+	RestoreCompleteConditionType = "RestoreComplete"
+)
+
+// Composed resource states reported in the backup summary
+const (
+	// ResourceStateRestored means stored values were restored into the desired resource
+	ResourceStateRestored = "Restored"
+	// ResourceStateStored means new values were backed up to the store
+	ResourceStateStored = "Stored"
+	// ResourceStateUnchanged means the resource has stored data and needed no restore or backup
+	ResourceStateUnchanged = "Unchanged"
+	// ResourceStateDeleted means the resource's stored data was deleted
+	ResourceStateDeleted = "Deleted"
+	// ResourceStatePurged means the resource's stored data was purged by the purge-resources annotation
+	ResourceStatePurged = "Purged"
+	// ResourceStateDeletePending means deleting the resource's stored data failed and is retried on the next reconcile
+	ResourceStateDeletePending = "DeletePending"
+	// ResourceStateSkipped means the resource was excluded from restore and backup
+	ResourceStateSkipped = "Skipped"
+	// ResourceStateMissing means the resource has neither stored data nor an external name yet
+	ResourceStateMissing = "Missing"
+)
+
+// resourceReport is the state of a composed resource in the backup summary
+type resourceReport struct {
+	State        string `json:"state"`
+	ExternalName string `json:"externalName,omitempty"`
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// runReport records what a function run did. It is written to the XR status as the backup summary.
+type runReport struct {
+	CompositionKey string                     `json:"compositionKey"`
+	ClusterID      string                     `json:"clusterId"`
+	StoreType      string                     `json:"storeType"`
+	Mode           string                     `json:"mode,omitempty"`
+	LastBackupTime string                     `json:"lastBackupTime,omitempty"`
+	Restored       int                        `json:"restored"`
+	Stored         int                        `json:"stored"`
+	Deleted        int                        `json:"deleted"`
+	Resources      map[string]*resourceReport `json:"resources,omitempty"`
+	Config         map[string]configValue     `json:"config,omitempty"`
+
+	// purgeFailed is set when purging the empty composition failed
+	purgeFailed bool
+}
+
+// record sets the state of a composed resource, replacing any earlier state. A deletion from the
+// store is final for the run, so later passes over the resource don't hide it.
+func (r *runReport) record(resourceName, state string, data ResourceData) {
+	if r.Resources == nil {
+		r.Resources = make(map[string]*resourceReport)
+	}
+	if rr, ok := r.Resources[resourceName]; ok && (rr.State == ResourceStateDeleted || rr.State == ResourceStateDeletePending) {
+		return
+	}
+	r.Resources[resourceName] = &resourceReport{State: state, ExternalName: data.ExternalName, ResourceName: data.ResourceName}
+}
+
+// withState returns the sorted names of the resources in a state
+func (r *runReport) withState(state string) []string {
+	var names []string
+	for name, rr := range r.Resources {
+		if rr.State == state {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// summary returns the backup summary with its counts filled in
+func (r *runReport) summary() (*structpb.Struct, error) {
+	r.Restored = len(r.withState(ResourceStateRestored))
+	r.Stored = len(r.withState(ResourceStateStored))
+	r.Deleted = len(r.withState(ResourceStateDeleted))
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	if err := s.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// getStatusField returns the XR status field to write the backup summary to, or "" if none is
+// configured. The summary is opt-in because Crossplane rejects status fields the XRD doesn't declare.
+func getStatusField(in *v1beta1.Input) (string, error) {
+	if in.Status == nil || in.Status.Field == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(in.Status.Field, "status.") || slices.Contains(strings.Split(in.Status.Field, "."), "") {
+		return "", errors.Errorf("invalid status field %q: expected a dot-separated path starting with \"status.\"", in.Status.Field)
+	}
+	return in.Status.Field, nil
+}
+
+// getFieldValue returns the value at a dot-separated path in a struct, or nil if there is none
+func getFieldValue(s *structpb.Struct, path string) *structpb.Value {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		s = s.GetFields()[p].GetStructValue()
+	}
+	return s.GetFields()[parts[len(parts)-1]]
+}
+
+// setDesiredCompositeField sets the value at a dot-separated path in the desired composite,
+// creating the desired composite and intermediate fields as needed
+func setDesiredCompositeField(rsp *fnv1.RunFunctionResponse, path string, value *structpb.Value) {
+	if rsp.GetDesired() == nil {
+		rsp.Desired = &fnv1.State{}
+	}
+	if rsp.GetDesired().GetComposite() == nil {
+		rsp.Desired.Composite = &fnv1.Resource{}
+	}
+	if rsp.GetDesired().GetComposite().GetResource() == nil {
+		rsp.Desired.Composite.Resource = &structpb.Struct{}
+	}
+
+	s := rsp.GetDesired().GetComposite().GetResource()
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		if s.Fields == nil {
+			s.Fields = make(map[string]*structpb.Value)
+		}
+		next := s.GetFields()[p].GetStructValue()
+		if next == nil {
+			next = &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+			s.Fields[p] = structpb.NewStructValue(next)
+		}
+		s = next
+	}
+	if s.Fields == nil {
+		s.Fields = make(map[string]*structpb.Value)
+	}
+	s.Fields[parts[len(parts)-1]] = value
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// resourceSelectorKindPrefix marks a resource selector entry that matches composed resources by kind
+const resourceSelectorKindPrefix = "kind:"
+
+// resourceSelector selects composed resources by pipeline resource name, which may contain
+// a single '*' wildcard, or by kind using a "kind:<Kind>" entry
+type resourceSelector []string
+
+// parseResourceSelector parses a comma-separated list of resource selector entries
+func parseResourceSelector(value string) resourceSelector {
+	var s resourceSelector
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			s = append(s, entry)
+		}
+	}
+	return s
+}
+
+// matches reports whether the selector selects a resource. Kind entries never match
+// resources of unknown kind, e.g. entries that only exist in the store.
+func (s resourceSelector) matches(name, kind string) bool {
+	for _, entry := range s {
+		if k, ok := strings.CutPrefix(entry, resourceSelectorKindPrefix); ok {
+			if kind != "" && k == kind {
+				return true
+			}
+			continue
+		}
+		if _, ok := matchWildcard(entry, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getResourceKind returns the kind of a composed resource, checking desired first, then observed
+func getResourceKind(req *fnv1.RunFunctionRequest, resourceName string) string {
+	if r, ok := req.GetDesired().GetResources()[resourceName]; ok {
+		if kind := r.GetResource().GetFields()["kind"].GetStringValue(); kind != "" {
+			return kind
+		}
+	}
+	if r, ok := req.GetObserved().GetResources()[resourceName]; ok {
+		return r.GetResource().GetFields()["kind"].GetStringValue()
+	}
+	return ""
+}
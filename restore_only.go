@@ -0,0 +1,67 @@
+package main
+
+import (
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	// RestoreOnlyModeAll requires stored data for every desired resource in restore-only mode
+	RestoreOnlyModeAll = "all"
+	// RestoreOnlyModeGeneratedNames requires stored data only for resources selected by
+	// the input's generatedNameResources, whose external names can't be recreated
+	RestoreOnlyModeGeneratedNames = "generated-names"
+)
+
+// restoreOnlyRequirement decides which desired resources must have stored data in restore-only mode
+type restoreOnlyRequirement struct {
+	mode           string
+	allowMissing   resourceSelector
+	generatedNames []v1beta1.ResourceSelector
+}
+
+// getRestoreOnlyRequirement combines the restore-only annotations with the function input
+func getRestoreOnlyRequirement(req *fnv1.RunFunctionRequest, in *v1beta1.Input) (*restoreOnlyRequirement, error) {
+	r := &restoreOnlyRequirement{
+		mode:         RestoreOnlyModeAll,
+		allowMissing: parseResourceSelector(getCompositeConfigAnnotation(req, RestoreOnlyAllowMissingAnnotation)),
+	}
+	if mode := getCompositeConfigAnnotation(req, RestoreOnlyModeAnnotation); mode != "" {
+		r.mode = mode
+	}
+	if in.Restore != nil {
+		r.allowMissing = append(r.allowMissing, in.Restore.AllowMissing...)
+		r.generatedNames = in.Restore.GeneratedNameResources
+	}
+
+	switch r.mode {
+	case RestoreOnlyModeAll:
+	case RestoreOnlyModeGeneratedNames:
+		if len(r.generatedNames) == 0 {
+			return nil, errors.Errorf("%s %q requires restore.generatedNameResources in the function input", RestoreOnlyModeAnnotation, r.mode)
+		}
+	default:
+		return nil, errors.Errorf("unsupported %s %q (supported modes: '%s', '%s')",
+			RestoreOnlyModeAnnotation, r.mode, RestoreOnlyModeAll, RestoreOnlyModeGeneratedNames)
+	}
+	return r, nil
+}
+
+// required reports whether a desired resource must have stored data
+func (r *restoreOnlyRequirement) required(resourceName string, fields map[string]*structpb.Value) bool {
+	if r.allowMissing.matches(resourceName, fields["kind"].GetStringValue()) {
+		return false
+	}
+	if r.mode != RestoreOnlyModeGeneratedNames {
+		return true
+	}
+	for _, s := range r.generatedNames {
+		if resourceMatchesSelector(s, fields) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
 )
 
 // ResourceData holds backup data for a composed resource
@@ -27,5 +32,77 @@ type ResourceStore interface {
 	DeleteResource(ctx context.Context, clusterID, compositionKey, resourceKey string) error
 }
 
+// A CompositionLister is a ResourceStore that can list the compositions stored for a cluster.
+// Stores don't have to implement it, commands that need it check for it.
+type CompositionLister interface {
+	// ListCompositions retrieves the resource data of all compositions of a cluster, keyed by composition key
+	ListCompositions(ctx context.Context, clusterID string) (map[string]map[string]ResourceData, error)
+}
+
 // ExternalNameStore is an alias for ResourceStore for backward compatibility
 type ExternalNameStore = ResourceStore
+
+// clientTLSConfig returns the TLS configuration of a store client from its credentials: an
+// optional CA that verifies the server (ca.crt) and an optional client certificate (tls.crt, tls.key)
+func clientTLSConfig(creds map[string]string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca := creds["ca.crt"]; ca != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("failed to parse ca.crt of store credentials")
+		}
+		config.RootCAs = pool
+	}
+	if creds["tls.crt"] != "" || creds["tls.key"] != "" {
+		cert, err := tls.X509KeyPair([]byte(creds["tls.crt"]), []byte(creds["tls.key"]))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse client certificate of store credentials")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newStore creates the external store selected by the configuration, with the store's credentials
+func newStore(ctx context.Context, log logging.Logger, config *FunctionConfig, creds map[string]string) (ResourceStore, error) {
+	switch config.StoreType {
+	case "awsdynamodb":
+		store, err := NewDynamoDBStore(ctx, log, config.DynamoDBTable, config.DynamoDBRegion, creds)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize DynamoDB store")
+		}
+		return store, nil
+	case "mock":
+		store, err := NewMockStore(ctx, log)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize Mock store")
+		}
+		return store, nil
+	case "k8sconfigmap":
+		store, err := NewConfigMapStore(ctx, log, config.ConfigMapNamespace)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize ConfigMap store")
+		}
+		return store, nil
+	case "git":
+		store, err := NewGitStore(ctx, log, config.GitRepository, config.GitBranch, creds)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize git store")
+		}
+		return store, nil
+	case "http":
+		store, err := NewHTTPStore(ctx, log, config.HTTPEndpoint, creds)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize HTTP store")
+		}
+		return store, nil
+	case "plugin":
+		store, err := NewPluginStore(ctx, log, config.PluginAddress, creds)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize plugin store")
+		}
+		return store, nil
+	default:
+		return nil, errors.Errorf("unsupported external store type: %s (supported types: 'awsdynamodb', 'mock', 'k8sconfigmap', 'git', 'http', 'plugin')", config.StoreType)
+	}
+}
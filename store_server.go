@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/crossplane/function-sdk-go/logging"
+)
+
+// storeServer serves the HTTP store protocol, backed by another store. It is the reference
+// implementation of the protocol for systems that want to receive the function's backups.
+type storeServer struct {
+	log   logging.Logger
+	store ResourceStore
+	// token is the bearer token clients must present, if set
+	token string
+}
+
+// newStoreServer returns the handler of the HTTP store protocol for a store
+func newStoreServer(log logging.Logger, store ResourceStore, token string) http.Handler {
+	s := &storeServer{log: log, store: store, token: token}
+
+	compositions := "/" + HTTPStoreAPIVersion + "/clusters/{cluster}/compositions"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+compositions, s.list)
+	mux.HandleFunc("GET "+compositions+"/{key}", s.load)
+	mux.HandleFunc("PUT "+compositions+"/{key}", s.save)
+	mux.HandleFunc("DELETE "+compositions+"/{key}", s.purge)
+	mux.HandleFunc("DELETE "+compositions+"/{key}/resources/{resource}", s.deleteResource)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the bearer token, if the server has one
+func (s *storeServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			s.write(w, r, http.StatusUnauthorized, httpError{Error: "missing or invalid bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// write writes a JSON response
+func (s *storeServer) write(w http.ResponseWriter, r *http.Request, status int, body any) {
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.log.Info("Failed to write store response", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	}
+}
+
+// fail writes the error of a failed store operation
+func (s *storeServer) fail(w http.ResponseWriter, r *http.Request, err error) {
+	s.log.Info("Store request failed", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	s.write(w, r, http.StatusInternalServerError, httpError{Error: err.Error()})
+}
+
+// list serves the compositions of a cluster
+func (s *storeServer) list(w http.ResponseWriter, r *http.Request) {
+	lister, ok := s.store.(CompositionLister)
+	if !ok {
+		s.write(w, r, http.StatusNotImplemented, httpError{Error: "the backing store cannot list its compositions"})
+		return
+	}
+	compositions, err := lister.ListCompositions(r.Context(), r.PathValue("cluster"))
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	s.write(w, r, http.StatusOK, httpCompositionList{Compositions: compositions})
+}
+
+// load serves a composition
+func (s *storeServer) load(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	resources, err := s.store.Load(r.Context(), r.PathValue("cluster"), key)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if resources == nil {
+		resources = make(map[string]ResourceData)
+	}
+	s.write(w, r, http.StatusOK, httpComposition{CompositionKey: key, Resources: resources})
+}
+
+// save stores a composition
+func (s *storeServer) save(w http.ResponseWriter, r *http.Request) {
+	c := httpComposition{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, httpStoreMaxBody)).Decode(&c); err != nil {
+		s.write(w, r, http.StatusBadRequest, httpError{Error: "expected a composition: " + err.Error()})
+		return
+	}
+	key := r.PathValue("key")
+	if c.CompositionKey != "" && c.CompositionKey != key {
+		s.write(w, r, http.StatusBadRequest, httpError{Error: "the composition key of the body doesn't match the path"})
+		return
+	}
+	if err := s.store.Save(r.Context(), r.PathValue("cluster"), key, c.Resources); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	s.log.Debug("Saved composition", "cluster-id", r.PathValue("cluster"), "composition-key", key, "resource-count", len(c.Resources))
+	s.write(w, r, http.StatusNoContent, nil)
+}
+
+// purge removes a composition
+func (s *storeServer) purge(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Purge(r.Context(), r.PathValue("cluster"), r.PathValue("key")); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	s.write(w, r, http.StatusNoContent, nil)
+}
+
+// deleteResource removes a resource of a composition
+func (s *storeServer) deleteResource(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteResource(r.Context(), r.PathValue("cluster"), r.PathValue("key"), r.PathValue("resource")); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	s.write(w, r, http.StatusNoContent, nil)
+}
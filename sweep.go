@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-external-name-backup-restore/input/v1beta1"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+const (
+	// SweepRequirementPrefix prefixes the required resource keys of the swept managed resources
+	SweepRequirementPrefix = "external-name-backup-sweep/"
+	// SweepCompositesRequirementPrefix prefixes the required resource keys of the composites of the
+	// swept managed resources, followed by their API version and kind
+	SweepCompositesRequirementPrefix = SweepRequirementPrefix + "composites/"
+
+	// CompositeLabel is the label Crossplane sets on composed resources to the name of their composite
+	CompositeLabel = "crossplane.io/composite"
+	// CompositionResourceNameAnnotation is the annotation Crossplane sets on composed resources to
+	// their pipeline resource name
+	CompositionResourceNameAnnotation = "crossplane.io/composition-resource-name"
+)
+
+// sweepSummary counts what a sweep did
+type sweepSummary struct {
+	resources    int
+	notComposed  int
+	skipped      int
+	stored       int
+	unchanged    int
+	compositions int
+}
+
+// sweptOwner identifies the composite of a swept managed resource
+type sweptOwner struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+
+	// parts are the composition key parts derived from the managed resource, used when the
+	// composite is gone
+	parts compositionKeyParts
+}
+
+// sweptComposite is how a sweep or an import backs up the managed resources of a composite,
+// following the composite's annotations like its own run does
+type sweptComposite struct {
+	compositionKey string
+	backupScope    string
+	// purged selects the resources whose stored data the composite purged
+	purged resourceSelector
+	// skip is why the composite's resources aren't swept, if they aren't
+	skip string
+}
+
+// runSweep backs up the external names of the managed resources selected by the input's sweep,
+// under the composition keys of their composites
+func (f *Function) runSweep(ctx context.Context, req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, in *v1beta1.Input) {
+	// Request the selected managed resources. Crossplane calls the function again once it has fetched them.
+	requireSweepResources(rsp, in.Sweep.Resources)
+	for i := range in.Sweep.Resources {
+		if _, ok := req.GetExtraResources()[sweepRequirementKey(i)]; !ok {
+			f.log.Info("Requesting managed resources to sweep as required resources", "count", len(in.Sweep.Resources))
+			return
+		}
+	}
+
+	// Request the composites of the managed resources, whose annotations decide where and whether
+	// their data is backed up
+	owners := make(map[string]sweptOwner)
+	for i := range in.Sweep.Resources {
+		for _, item := range req.GetExtraResources()[sweepRequirementKey(i)].GetItems() {
+			if owner, _, ok := sweptOwnerOf(item.GetResource()); ok {
+				owners[sweepCompositesRequirementKey(owner.APIVersion, owner.Kind)] = owner
+			}
+		}
+	}
+	requireSweepComposites(rsp, owners)
+	for key := range owners {
+		if _, ok := req.GetExtraResources()[key]; !ok {
+			f.log.Info("Requesting composites of swept managed resources as required resources", "kinds", len(owners))
+			return
+		}
+	}
+	composites := make(map[sweptOwner]*structpb.Struct)
+	for key := range owners {
+		for _, item := range req.GetExtraResources()[key].GetItems() {
+			xr := item.GetResource()
+			metadata := xr.GetFields()["metadata"].GetStructValue().GetFields()
+			composites[sweptOwner{
+				APIVersion: xr.GetFields()["apiVersion"].GetStringValue(),
+				Kind:       xr.GetFields()["kind"].GetStringValue(),
+				Namespace:  metadata["namespace"].GetStringValue(),
+				Name:       metadata["name"].GetStringValue(),
+			}] = xr
+		}
+	}
+
+	config, err := f.getConfig(req, in)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return
+	}
+	// The sweep writes to the same store as the composites' runs, so it is pinned by the same policy
+	if err := applyStorePolicy(req, in.Policy, config); err != nil {
+		response.Fatal(rsp, err)
+		return
+	}
+	if err := f.completeClusterID(ctx, config); err != nil {
+		response.Fatal(rsp, err)
+		return
+	}
+	f.log.Info("Effective configuration", "config", config.describe())
+
+	// A sweep only writes, so it's pointless under a refused cluster ID
+	if config.ClusterID == DefaultClusterID && !f.allowDefaultClusterID {
+		response.Fatal(rsp, errors.Errorf("refusing to sweep into the external store under the %q cluster ID: configure a cluster ID or cluster ID source", DefaultClusterID))
+		return
+	}
+
+	storeCreds, err := getStoreCredentials(req, config.StoreType)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return
+	}
+	store, err := newStore(ctx, f.log, config, storeCreds)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return
+	}
+
+	// Group the resource data of the managed resources by the composition key of their composite
+	summary := sweepSummary{}
+	swept := make(map[sweptOwner]sweptComposite)
+	compositions := make(map[string]map[string]ResourceData)
+	for i := range in.Sweep.Resources {
+		for _, item := range req.GetExtraResources()[sweepRequirementKey(i)].GetItems() {
+			summary.resources++
+			resource := item.GetResource()
+			owner, resourceName, ok := sweptOwnerOf(resource)
+			if !ok {
+				summary.notComposed++
+				continue
+			}
+
+			c, ok := swept[owner]
+			if !ok {
+				if c, err = f.sweepComposite(ctx, store, config, in, owner, composites[owner.id()]); err != nil {
+					response.Fatal(rsp, err)
+					return
+				}
+				if c.skip != "" {
+					f.log.Info("Skipping managed resources of composite", "composite", owner.Name, "kind", owner.Kind, "reason", c.skip)
+				}
+				swept[owner] = c
+			}
+			if c.skip != "" || c.purged.matches(resourceName, resource.GetFields()["kind"].GetStringValue()) {
+				summary.skipped++
+				continue
+			}
+
+			data := f.sweptResourceData(resource, resourceName, c.backupScope, in.Backup)
+			if data == (ResourceData{}) {
+				continue
+			}
+			if compositions[c.compositionKey] == nil {
+				compositions[c.compositionKey] = make(map[string]ResourceData)
+			}
+			compositions[c.compositionKey][resourceName] = data
+		}
+	}
+
+	// Merge the resource data into the store, writing only compositions that changed
+	for _, compositionKey := range slices.Sorted(maps.Keys(compositions)) {
+		stored, err := store.Load(ctx, config.ClusterID, compositionKey)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "failed to load resource data of composition %q from store", compositionKey))
+			return
+		}
+
+		merged, changed, unchanged := mergeResourceData(stored, compositions[compositionKey])
+		summary.unchanged += unchanged
+		if changed == 0 {
+			continue
+		}
+
+		if err := store.Save(ctx, config.ClusterID, compositionKey, merged); err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "failed to save resource data of composition %q to store", compositionKey))
+			return
+		}
+		f.log.Info("Swept resource data into store", "composition-key", compositionKey, "stored-count", changed)
+		summary.stored += changed
+		summary.compositions++
+	}
+
+	f.log.Info("Sweep complete",
+		"cluster-id", config.ClusterID,
+		"resources", summary.resources,
+		"stored", summary.stored,
+		"compositions", summary.compositions,
+		"unchanged", summary.unchanged,
+		"skipped", summary.skipped,
+		"not-composed", summary.notComposed)
+	response.Normalf(rsp, "Swept %d managed resources into cluster %s: backed up %d resources of %d compositions, %d unchanged, %d skipped, %d not composed",
+		summary.resources, strconv.Quote(config.ClusterID), summary.stored, summary.compositions, summary.unchanged, summary.skipped, summary.notComposed)
+}
+
+// sweepComposite decides how the managed resources of a composite are swept, or imported by the
+// import command. It applies the checks of the composite's own run, so a bulk backup doesn't write
+// where the run refuses to or wouldn't read: the override policy, restore-only mode, purges,
+// configuration annotations that select another store or cluster, and composition key overrides.
+// A composition with a tombstone isn't backed up, so purged data isn't written back before the
+// composite's own run removes the expired tombstone. A composite that is gone is swept under the
+// key derived from its managed resources.
+func (f *Function) sweepComposite(ctx context.Context, store ResourceStore, config *FunctionConfig, in *v1beta1.Input, owner sweptOwner, xr *structpb.Struct) (sweptComposite, error) {
+	c := sweptComposite{compositionKey: owner.parts.String(), backupScope: config.BackupScope}
+	if xr == nil {
+		return c, c.skipPurged(ctx, store, config.ClusterID)
+	}
+
+	req := &fnv1.RunFunctionRequest{Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}}}
+	switch {
+	case !shouldEnableExternalStore(req, f.log):
+		c.skip = "it doesn't enable the external store"
+		return c, nil
+	case shouldRequireRestore(req):
+		c.skip = "it is in restore-only mode"
+		return c, nil
+	case shouldPurgeExternalStore(req, f.log) || isTrue(getCompositeAnnotation(req, UndeleteExternalStoreAnnotation)):
+		c.skip = "it is being purged or undeleted"
+		return c, nil
+	}
+	if err := checkOverridePolicy(req, in.Policy); err != nil {
+		c.skip = err.Error()
+		return c, nil
+	}
+	for _, s := range configSettings {
+		if s.annotation == "" || s.name == ConfigBackupScope || s.name == ConfigConfigMapReads {
+			continue
+		}
+		if v := getCompositeConfigAnnotation(req, s.annotation); v != "" && v != *s.field(config) {
+			c.skip = fmt.Sprintf("its %s annotation selects another store or cluster", s.annotation)
+			return c, nil
+		}
+	}
+
+	if scope := getCompositeConfigAnnotation(req, BackupScopeAnnotation); scope != "" {
+		c.backupScope = scope
+	}
+	parts := compositeKeyParts(xr)
+	c.compositionKey = f.applyKeyOverrides(&parts, func(annotation string) string {
+		return getCompositeAnnotation(req, annotation)
+	})
+	c.purged = parseResourceSelector(getCompositeAnnotation(req, PurgeResourcesAnnotation))
+	return c, c.skipPurged(ctx, store, config.ClusterID)
+}
+
+// skipPurged skips the composition if it has a tombstone
+func (c *sweptComposite) skipPurged(ctx context.Context, store ResourceStore, clusterID string) error {
+	t, err := loadTombstone(ctx, store, clusterID, c.compositionKey)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load tombstone of composition %q from store", c.compositionKey)
+	}
+	if t != nil {
+		c.skip = fmt.Sprintf("its composition was purged at %s", t.PurgedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// sweptResourceData returns the resource data of a swept managed resource. Like a composite's run, it
+// backs up the external name within the backup scope, and the name regardless of it.
+func (f *Function) sweptResourceData(resource *structpb.Struct, resourceName, backupScope string, backup *v1beta1.Backup) ResourceData {
+	fields := resource.GetFields()
+	policy := f.backupPolicy(getAnnotationValue(resource, BackupPolicyAnnotation), backup, resourceName, fields)
+	if policy == BackupPolicyNever {
+		return ResourceData{}
+	}
+
+	data := ResourceData{ResourceName: getMetadataName(resource)}
+	if f.shouldProcessResourceWithPolicy(fields, resourceName, backupScope, policy) {
+		data.ExternalName = getAnnotationValue(resource, "crossplane.io/external-name")
+	}
+	return data
+}
+
+// mergeResourceData merges live resource data into the stored resource data of a composition. Set
+// values replace stored ones, and stored resources without live data are kept. It returns the merged
+// data and the number of resources that changed and that were unchanged.
+func mergeResourceData(stored, live map[string]ResourceData) (map[string]ResourceData, int, int) {
+	merged := maps.Clone(stored)
+	if merged == nil {
+		merged = make(map[string]ResourceData)
+	}
+	changed, unchanged := 0, 0
+	for name, data := range live {
+		existing := merged[name]
+		updated := existing
+		if data.ExternalName != "" {
+			updated.ExternalName = data.ExternalName
+		}
+		if data.ResourceName != "" {
+			updated.ResourceName = data.ResourceName
+		}
+		if updated == existing {
+			unchanged++
+			continue
+		}
+		merged[name] = updated
+		changed++
+	}
+	return merged, changed, unchanged
+}
+
+// sweepRequirementKey returns the required resource key of a sweep resource selector
+func sweepRequirementKey(i int) string {
+	return SweepRequirementPrefix + strconv.Itoa(i)
+}
+
+// sweepCompositesRequirementKey returns the required resource key of the composites of a kind
+func sweepCompositesRequirementKey(apiVersion, kind string) string {
+	return SweepCompositesRequirementPrefix + apiVersion + "/" + kind
+}
+
+// requireSweepComposites requests all composites of the kinds of the swept managed resources' composites
+func requireSweepComposites(rsp *fnv1.RunFunctionResponse, owners map[string]sweptOwner) {
+	if len(owners) == 0 {
+		return
+	}
+	if rsp.GetRequirements() == nil {
+		rsp.Requirements = &fnv1.Requirements{}
+	}
+	if rsp.GetRequirements().GetExtraResources() == nil {
+		rsp.Requirements.ExtraResources = make(map[string]*fnv1.ResourceSelector)
+	}
+	for key, owner := range owners {
+		rsp.Requirements.ExtraResources[key] = &fnv1.ResourceSelector{
+			ApiVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Match: &fnv1.ResourceSelector_MatchLabels{
+				MatchLabels: &fnv1.MatchLabels{},
+			},
+		}
+	}
+}
+
+// requireSweepResources requests the managed resources selected by the sweep as required resources
+func requireSweepResources(rsp *fnv1.RunFunctionResponse, resources []v1beta1.SweepResource) {
+	if rsp.GetRequirements() == nil {
+		rsp.Requirements = &fnv1.Requirements{}
+	}
+	if rsp.GetRequirements().GetExtraResources() == nil {
+		rsp.Requirements.ExtraResources = make(map[string]*fnv1.ResourceSelector)
+	}
+	for i, r := range resources {
+		rsp.Requirements.ExtraResources[sweepRequirementKey(i)] = &fnv1.ResourceSelector{
+			ApiVersion: r.APIVersion,
+			Kind:       r.Kind,
+			Match: &fnv1.ResourceSelector_MatchLabels{
+				MatchLabels: &fnv1.MatchLabels{Labels: r.MatchLabels},
+			},
+		}
+	}
+}
+
+// id returns the owner without its derived key parts, to look up its composite
+func (o sweptOwner) id() sweptOwner {
+	return sweptOwner{APIVersion: o.APIVersion, Kind: o.Kind, Namespace: o.Namespace, Name: o.Name}
+}
+
+// sweptOwnerOf derives the composite and pipeline resource name of a managed resource from the
+// labels, annotations and controller reference Crossplane sets on composed resources.
+// It returns false for resources that aren't composed.
+func sweptOwnerOf(resource *structpb.Struct) (sweptOwner, string, bool) {
+	metadata := resource.GetFields()["metadata"].GetStructValue().GetFields()
+	labels := metadata["labels"].GetStructValue().GetFields()
+
+	resourceName := getAnnotationValue(resource, CompositionResourceNameAnnotation)
+	xrName := labels[CompositeLabel].GetStringValue()
+	if resourceName == "" || xrName == "" {
+		return sweptOwner{}, "", false
+	}
+
+	// The controller reference identifies the composite's API version and kind
+	var xrAPIVersion, xrKind string
+	for _, ref := range metadata["ownerReferences"].GetListValue().GetValues() {
+		r := ref.GetStructValue().GetFields()
+		if r["controller"].GetBoolValue() && r["name"].GetStringValue() == xrName {
+			xrAPIVersion = r["apiVersion"].GetStringValue()
+			xrKind = r["kind"].GetStringValue()
+		}
+	}
+	if xrAPIVersion == "" || xrKind == "" {
+		return sweptOwner{}, "", false
+	}
+
+	// Same fallbacks as a composite's run: the claim, then the namespace of a namespaced
+	// composite, which composes resources into its own namespace
+	parts := compositionKeyParts{
+		Namespace:  labels["crossplane.io/claim-namespace"].GetStringValue(),
+		ClaimName:  labels["crossplane.io/claim-name"].GetStringValue(),
+		APIVersion: xrAPIVersion,
+		Kind:       xrKind,
+		Name:       xrName,
+	}
+	if parts.Namespace == "" {
+		parts.Namespace = metadata["namespace"].GetStringValue()
+	}
+	if parts.Namespace == "" {
+		parts.Namespace = "none"
+	}
+	if parts.ClaimName == "" {
+		parts.ClaimName = "none"
+	}
+
+	// A namespaced composite composes resources into its own namespace
+	owner := sweptOwner{APIVersion: xrAPIVersion, Kind: xrKind, Name: xrName, parts: parts}
+	if labels["crossplane.io/claim-name"].GetStringValue() == "" {
+		owner.Namespace = metadata["namespace"].GetStringValue()
+	}
+	return owner, resourceName, true
+}
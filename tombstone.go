@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// DefaultPurgeRetention is how long purged resource data can be undeleted
+	DefaultPurgeRetention = 30 * 24 * time.Hour
+
+	// tombstoneKeySuffix is appended to a composition key to form the key its purged data is kept under
+	tombstoneKeySuffix = "#tombstone"
+
+	// tombstoneMetadataKey is a reserved resource key in a tombstone. Its ExternalName
+	// holds the purge token and its ResourceName holds the purge timestamp.
+	tombstoneMetadataKey = "fn.crossplane.io/tombstone"
+)
+
+// tombstone holds the resource data of a purged composition until its retention expires
+type tombstone struct {
+	Resources map[string]ResourceData
+	Token     string
+	PurgedAt  time.Time
+}
+
+// tombstoneKey returns the composition key a composition's tombstone is stored under
+func tombstoneKey(compositionKey string) string {
+	return compositionKey + tombstoneKeySuffix
+}
+
+// expired reports whether the tombstone is older than the retention
+func (t *tombstone) expired(now time.Time, retention time.Duration) bool {
+	return now.Sub(t.PurgedAt) > retention
+}
+
+// loadTombstone loads the tombstone of a composition, returning nil if there is none
+func loadTombstone(ctx context.Context, store ResourceStore, clusterID, compositionKey string) (*tombstone, error) {
+	data, err := store.Load(ctx, clusterID, tombstoneKey(compositionKey))
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := data[tombstoneMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+	purgedAt, err := time.Parse(time.RFC3339, meta.ResourceName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid purge timestamp %q in tombstone", meta.ResourceName)
+	}
+	delete(data, tombstoneMetadataKey)
+	return &tombstone{Resources: data, Token: meta.ExternalName, PurgedAt: purgedAt}, nil
+}
+
+// softPurge moves a composition's resource data to its tombstone and purges the composition.
+// The entries of a previous tombstone that is still within its retention are kept, unless the
+// composition has an entry of the same resource. It returns the number of resource entries moved.
+func softPurge(ctx context.Context, store ResourceStore, clusterID, compositionKey, token string, previous *tombstone, now time.Time) (int, error) {
+	resources, err := store.Load(ctx, clusterID, compositionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	data := make(map[string]ResourceData, len(resources)+1)
+	if previous != nil {
+		for k, v := range previous.Resources {
+			data[k] = v
+		}
+	}
+	for k, v := range resources {
+		data[k] = v
+	}
+	data[tombstoneMetadataKey] = ResourceData{ExternalName: token, ResourceName: now.UTC().Format(time.RFC3339)}
+
+	if err := store.Save(ctx, clusterID, tombstoneKey(compositionKey), data); err != nil {
+		return 0, errors.Wrap(err, "failed to save tombstone")
+	}
+	if err := store.Purge(ctx, clusterID, compositionKey); err != nil {
+		return 0, err
+	}
+	return len(resources), nil
+}
+
+// hardPurge purges a composition without keeping its resource data. It leaves a tombstone without
+// resources that records the purge token, so the purge is performed once per token.
+func hardPurge(ctx context.Context, store ResourceStore, clusterID, compositionKey, token string, now time.Time) error {
+	if err := store.Purge(ctx, clusterID, compositionKey); err != nil {
+		return err
+	}
+	marker := map[string]ResourceData{
+		tombstoneMetadataKey: {ExternalName: token, ResourceName: now.UTC().Format(time.RFC3339)},
+	}
+	if err := store.Save(ctx, clusterID, tombstoneKey(compositionKey), marker); err != nil {
+		return errors.Wrap(err, "failed to save tombstone")
+	}
+	return nil
+}
+
+// undelete restores a tombstone's resource data to the composition and removes the tombstone.
+// Entries stored since the purge take precedence. It returns the number of resource entries restored.
+func undelete(ctx context.Context, store ResourceStore, clusterID, compositionKey string, t *tombstone) (int, error) {
+	resources, err := store.Load(ctx, clusterID, compositionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for k, v := range t.Resources {
+		if _, exists := resources[k]; exists {
+			continue
+		}
+		resources[k] = v
+		restored++
+	}
+
+	if restored > 0 {
+		if err := store.Save(ctx, clusterID, compositionKey, resources); err != nil {
+			return 0, err
+		}
+	}
+	if err := store.Purge(ctx, clusterID, tombstoneKey(compositionKey)); err != nil {
+		return 0, errors.Wrap(err, "failed to remove tombstone")
+	}
+	return restored, nil
+}
+
+// getPurgeTokens returns the values a purge annotation must match to purge the composite: its UID and generation
+func getPurgeTokens(composite *structpb.Struct) (uid, generation string) {
+	metadata := composite.GetFields()["metadata"].GetStructValue()
+	uid = metadata.GetFields()["uid"].GetStringValue()
+	if g := metadata.GetFields()["generation"]; g != nil {
+		generation = fmt.Sprintf("%d", int64(g.GetNumberValue()))
+	}
+	return uid, generation
+}